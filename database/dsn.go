@@ -29,3 +29,31 @@ func ParseDSN(dsn string) (driver string, cleanDSN string) {
 func FormatDSN(driver string, dsn string) string {
 	return fmt.Sprintf("%s://%s", driver, dsn)
 }
+
+// SharedSQLiteDSN adds to a SQLite DSN without scheme, as returned by
+// ParseDSN, the pragmas letting several connections and processes share the
+// database: a busy timeout, so writers wait for each other instead of
+// failing, and WAL journaling, except for in-memory databases. Pragmas
+// already in dsn are kept.
+// Example: "file.db" -> "file.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+func SharedSQLiteDSN(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	if !strings.Contains(dsn, "busy_timeout") {
+		dsn += sep + "_pragma=busy_timeout(5000)"
+		sep = "&"
+	}
+	if !strings.Contains(dsn, "journal_mode") && !IsSQLiteMemory(dsn) {
+		dsn += sep + "_pragma=journal_mode(WAL)"
+	}
+	return dsn
+}
+
+// IsSQLiteMemory reports whether a SQLite DSN without scheme names an
+// in-memory database. Every connection to one opens a database of its own,
+// unless the cache is shared.
+func IsSQLiteMemory(dsn string) bool {
+	return strings.Contains(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+}
@@ -143,3 +143,40 @@ func TestDSNRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestSharedSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsn      string
+		expected string
+	}{
+		{
+			name:     "File",
+			dsn:      "file.db",
+			expected: "file.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+		},
+		{
+			name:     "File with parameters",
+			dsn:      "file.db?_pragma=busy_timeout(100)",
+			expected: "file.db?_pragma=busy_timeout(100)&_pragma=journal_mode(WAL)",
+		},
+		{
+			name:     "In-memory",
+			dsn:      ":memory:",
+			expected: ":memory:?_pragma=busy_timeout(5000)",
+		},
+		{
+			name:     "Shared in-memory",
+			dsn:      "file::memory:?cache=shared",
+			expected: "file::memory:?cache=shared&_pragma=busy_timeout(5000)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := SharedSQLiteDSN(tt.dsn); result != tt.expected {
+				t.Errorf("SharedSQLiteDSN() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ module github.com/Guadalsistema/net-utils
 
 go 1.24.2
 
-require github.com/golang-migrate/migrate/v4 v4.18.3
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/golang-migrate/migrate/v4 v4.18.3
+	github.com/klauspost/compress v1.17.11
+	modernc.org/sqlite v1.18.1
+)
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
@@ -23,7 +28,6 @@ require (
 	modernc.org/mathutil v1.5.0 // indirect
 	modernc.org/memory v1.2.1 // indirect
 	modernc.org/opt v0.1.3 // indirect
-	modernc.org/sqlite v1.18.1 // indirect
 	modernc.org/strutil v1.1.3 // indirect
 	modernc.org/token v1.0.0 // indirect
 )
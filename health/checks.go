@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver (pure go)
+
+	"github.com/Guadalsistema/net-utils/database"
+	"github.com/Guadalsistema/net-utils/migrate"
+)
+
+// OpenDB opens the database of dsn, as accepted by database.ParseDSN. The
+// sqlite driver is registered by this package; other drivers must be
+// registered by the program.
+func OpenDB(dsn string) (*sql.DB, error) {
+	driver, cleanDSN := database.ParseDSN(dsn)
+	db, err := sql.Open(driver, cleanDSN)
+	if err != nil {
+		return nil, fmt.Errorf("health: opening database: %w", err)
+	}
+	return db, nil
+}
+
+// DB checks that db, e.g. opened with OpenDB, answers a query.
+func DB(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		var one int
+		if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+			return fmt.Errorf("database unreachable: %w", err)
+		}
+		return nil
+	}
+}
+
+// migrationFile matches the file names of the migrate file source.
+var migrationFile = regexp.MustCompile(`^([0-9]+)_.*\.up\.[^.]+$`)
+
+// latestMigration returns the highest version in migrationsDir.
+func latestMigration(migrationsDir string) (uint, error) {
+	entries, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return 0, err
+	}
+	var latest uint
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseUint(m[1], 10, 0)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(v))
+	}
+	return latest, nil
+}
+
+// Migrations checks through migrate.Version that the database of
+// databaseURL is not dirty and is at the expected version, or at the latest
+// version found in migrationsDir when expected is 0. Reading the version
+// opens a connection, so consider WithCacheTTL.
+func Migrations(databaseURL, migrationsDir string, expected uint) Check {
+	return func(ctx context.Context) error {
+		want := expected
+		if want == 0 {
+			latest, err := latestMigration(migrationsDir)
+			if err != nil {
+				return fmt.Errorf("reading migrations: %w", err)
+			}
+			want = latest
+		}
+		version, dirty, err := migrate.Version(databaseURL, migrationsDir)
+		switch {
+		case err != nil:
+			return err
+		case dirty:
+			return fmt.Errorf("migration %d is dirty", version)
+		case version != want:
+			return fmt.Errorf("database at migration %d, want %d", version, want)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package health_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/health"
+	"github.com/Guadalsistema/net-utils/migrate"
+)
+
+func TestDB(t *testing.T) {
+	db, err := health.OpenDB("sqlite://" + filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := health.DB(db)
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	db.Close()
+	if err := check(context.Background()); err == nil {
+		t.Fatal("closed database reported healthy")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "migrations")
+	os.Mkdir(migrations, 0o755)
+	for name, sql := range map[string]string{
+		"001_create_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"001_create_users.down.sql": "DROP TABLE users;",
+		"002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email TEXT;",
+		"002_add_email.down.sql":    "ALTER TABLE users DROP COLUMN email;",
+	} {
+		if err := os.WriteFile(filepath.Join(migrations, name), []byte(sql), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	databaseURL := "sqlite://" + filepath.Join(dir, "app.db")
+	latest := health.Migrations(databaseURL, migrations, 0)
+	pinned := health.Migrations(databaseURL, migrations, 2)
+
+	if err := latest(context.Background()); err == nil || !strings.Contains(err.Error(), "at migration 0, want 2") {
+		t.Fatalf("before migrating: %v", err)
+	}
+	if err := migrate.Up(databaseURL, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if err := latest(context.Background()); err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if err := pinned(context.Background()); err != nil {
+		t.Fatalf("pinned: %v", err)
+	}
+	if err := health.Migrations(databaseURL, migrations, 3)(context.Background()); err == nil {
+		t.Fatal("version 3 expected but not reported")
+	}
+}
@@ -0,0 +1,296 @@
+// Package health runs named checks of the components of a service and serves
+// their aggregated state on liveness and readiness endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/log"
+)
+
+// Endpoints served by Mount.
+const (
+	LivenessPath  = "/livez"
+	ReadinessPath = "/readyz"
+	HealthPath    = "/healthz" // same as ReadinessPath
+)
+
+// ContentType is the media type of health reports.
+const ContentType = "application/health+json"
+
+// DefaultTimeout bounds a check unless WithTimeout says otherwise.
+const DefaultTimeout = 5 * time.Second
+
+// Paths returns the endpoints served by Mount, e.g. to keep probes out of
+// the logs with middleware.WithSkipPaths.
+func Paths() []string {
+	return []string{LivenessPath, ReadinessPath, HealthPath}
+}
+
+// Status is the state of a check or of a whole report.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	// StatusWarn reports a failing non-critical check; the service is still
+	// considered healthy.
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Check reports the health of a component. It must give up when ctx is done.
+type Check func(ctx context.Context) error
+
+// CheckOption configures a registered check.
+type CheckOption func(*check)
+
+// WithTimeout bounds the duration of the check; DefaultTimeout when unset.
+func WithTimeout(d time.Duration) CheckOption {
+	return func(c *check) { c.timeout = d }
+}
+
+// NonCritical makes a failure of the check a warning: it is reported but
+// does not make the service unhealthy.
+func NonCritical() CheckOption {
+	return func(c *check) { c.critical = false }
+}
+
+// WithCacheTTL reuses the result of the check for d, sparing expensive
+// checks from being run at every probe.
+func WithCacheTTL(d time.Duration) CheckOption {
+	return func(c *check) { c.ttl = d }
+}
+
+// WithLiveness makes the check count for liveness too. Checks only count for
+// readiness by default: a failing dependency should take the service out of
+// rotation, not get it restarted.
+func WithLiveness() CheckOption {
+	return func(c *check) { c.liveness = true }
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Status   Status    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Critical bool      `json:"critical"`
+	Duration float64   `json:"durationMs"`
+	Time     time.Time `json:"time"`
+	Cached   bool      `json:"cached,omitempty"`
+}
+
+// Report aggregates the results of the checks.
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+type check struct {
+	name     string
+	fn       Check
+	timeout  time.Duration
+	critical bool
+	ttl      time.Duration
+	liveness bool
+
+	mu   sync.Mutex // serialises runs, so concurrent probes share a result
+	last Result
+}
+
+// NotReadyCheck is the name under which a readiness report explains that
+// SetReady(false) was called.
+const NotReadyCheck = "ready"
+
+// Checker holds the registered checks.
+type Checker struct {
+	logger   *slog.Logger
+	mu       sync.RWMutex
+	checks   []*check
+	notReady atomic.Bool
+}
+
+// NewChecker returns a checker logging the checks that start or stop
+// failing through l.
+func NewChecker(l *slog.Logger) *Checker {
+	return &Checker{logger: l}
+}
+
+// SetReady forces readiness to fail, whatever the checks say, while ready is
+// false; e.g. while draining before shutdown, so load balancers stop sending
+// traffic. Liveness is not affected.
+func (c *Checker) SetReady(ready bool) {
+	c.notReady.Store(!ready)
+}
+
+// Register adds a check. Registering a name again replaces the check.
+func (c *Checker) Register(name string, fn Check, opts ...CheckOption) {
+	ch := &check{name: name, fn: fn, timeout: DefaultTimeout, critical: true}
+	for _, opt := range opts {
+		opt(ch)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = slices.DeleteFunc(c.checks, func(x *check) bool { return x.name == name })
+	c.checks = append(c.checks, ch)
+}
+
+// Liveness runs the checks registered WithLiveness.
+func (c *Checker) Liveness(ctx context.Context) Report {
+	return c.run(ctx, func(ch *check) bool { return ch.liveness })
+}
+
+// Readiness runs every check. It fails without running them after
+// SetReady(false).
+func (c *Checker) Readiness(ctx context.Context) Report {
+	if c.notReady.Load() {
+		return Report{Status: StatusFail, Checks: map[string]Result{
+			NotReadyCheck: {Status: StatusFail, Error: "not ready", Critical: true, Time: time.Now()},
+		}}
+	}
+	return c.run(ctx, func(*check) bool { return true })
+}
+
+func (c *Checker) run(ctx context.Context, include func(*check) bool) Report {
+	c.mu.RLock()
+	var checks []*check
+	for _, ch := range c.checks {
+		if include(ch) {
+			checks = append(checks, ch)
+		}
+	}
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.runCheck(ctx, ch)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass, Checks: make(map[string]Result, len(checks))}
+	for i, ch := range checks {
+		report.Checks[ch.name] = results[i]
+		switch results[i].Status {
+		case StatusFail:
+			report.Status = StatusFail
+		case StatusWarn:
+			if report.Status == StatusPass {
+				report.Status = StatusWarn
+			}
+		}
+	}
+	return report
+}
+
+func (c *Checker) runCheck(ctx context.Context, ch *check) Result {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	now := time.Now()
+	if ch.ttl > 0 && !ch.last.Time.IsZero() && now.Sub(ch.last.Time) < ch.ttl {
+		res := ch.last
+		res.Cached = true
+		return res
+	}
+
+	// the check is bounded by its own timeout only, so a probe giving up
+	// early does not make it fail
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ch.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- fmt.Errorf("panic: %v", v)
+			}
+		}()
+		done <- ch.fn(checkCtx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-checkCtx.Done():
+		err = fmt.Errorf("check did not complete: %w", checkCtx.Err()) // the check ignores ctx
+	case <-ctx.Done():
+		// the caller is gone: the result says nothing about the check, so
+		// it is neither cached nor logged
+		return ch.result(now, fmt.Errorf("check abandoned: %w", ctx.Err()))
+	}
+
+	res := ch.result(now, err)
+	c.logTransition(ctx, ch, res)
+	ch.last = res
+	return res
+}
+
+// result is the result of a run of ch started at start and ending with err.
+func (ch *check) result(start time.Time, err error) Result {
+	res := Result{Status: StatusPass, Critical: ch.critical, Time: start, Duration: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status, res.Error = StatusFail, err.Error()
+		if !ch.critical {
+			res.Status = StatusWarn
+		}
+	}
+	return res
+}
+
+// logTransition logs checks that start failing or recover.
+func (c *Checker) logTransition(ctx context.Context, ch *check, res Result) {
+	failing := res.Status != StatusPass
+	wasFailing := !ch.last.Time.IsZero() && ch.last.Status != StatusPass
+	switch {
+	case failing && !wasFailing:
+		level := slog.LevelError
+		if !ch.critical {
+			level = slog.LevelWarn
+		}
+		log.Log(c.logger, ctx, level, "Health check failing", "check", ch.name, "error", res.Error)
+	case !failing && wasFailing:
+		log.Log(c.logger, ctx, slog.LevelInfo, "Health check recovered", "check", ch.name)
+	}
+}
+
+// LivenessHandler serves the liveness report: 200 unless a check fails,
+// 503 otherwise.
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(c.Liveness)
+}
+
+// ReadinessHandler serves the readiness report: 200 unless a critical check
+// fails, 503 otherwise.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(c.Readiness)
+}
+
+func (c *Checker) handler(run func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := run(r.Context())
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status == StatusFail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(report)
+		}
+	})
+}
+
+// Mount serves the liveness and readiness reports on mux, at LivenessPath,
+// ReadinessPath and HealthPath.
+func (c *Checker) Mount(mux *http.ServeMux) {
+	mux.Handle("GET "+LivenessPath, c.LivenessHandler())
+	mux.Handle("GET "+ReadinessPath, c.ReadinessHandler())
+	mux.Handle("GET "+HealthPath, c.ReadinessHandler())
+}
@@ -0,0 +1,175 @@
+package health_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/health"
+)
+
+func pass(context.Context) error { return nil }
+
+func fail(context.Context) error { return errors.New("down") }
+
+func TestChecker_Aggregation(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *health.Checker)
+		want  health.Status
+	}{
+		{"no checks", func(*health.Checker) {}, health.StatusPass},
+		{"all pass", func(c *health.Checker) { c.Register("db", pass) }, health.StatusPass},
+		{"non critical fails", func(c *health.Checker) {
+			c.Register("db", pass)
+			c.Register("cache", fail, health.NonCritical())
+		}, health.StatusWarn},
+		{"critical fails", func(c *health.Checker) {
+			c.Register("db", fail)
+			c.Register("cache", fail, health.NonCritical())
+		}, health.StatusFail},
+		{"replaced", func(c *health.Checker) {
+			c.Register("db", fail)
+			c.Register("db", pass)
+		}, health.StatusPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := health.NewChecker(slog.New(slog.DiscardHandler))
+			tt.setup(c)
+			if got := c.Readiness(context.Background()).Status; got != tt.want {
+				t.Fatalf("status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecker_TimeoutAndPanic(t *testing.T) {
+	c := health.NewChecker(slog.New(slog.DiscardHandler))
+	c.Register("stuck", func(context.Context) error { select {} }, health.WithTimeout(10*time.Millisecond))
+	c.Register("broken", func(context.Context) error { panic("oops") })
+
+	report := c.Readiness(context.Background())
+	if r := report.Checks["stuck"]; r.Status != health.StatusFail || !strings.Contains(r.Error, "deadline exceeded") {
+		t.Errorf("stuck = %+v", r)
+	}
+	if r := report.Checks["broken"]; r.Status != health.StatusFail || r.Error != "panic: oops" {
+		t.Errorf("broken = %+v", r)
+	}
+}
+
+func TestChecker_Cache(t *testing.T) {
+	var runs atomic.Int32
+	c := health.NewChecker(slog.New(slog.DiscardHandler))
+	c.Register("db", func(context.Context) error { runs.Add(1); return nil }, health.WithCacheTTL(time.Minute))
+
+	c.Readiness(context.Background())
+	report := c.Readiness(context.Background())
+	if runs.Load() != 1 || !report.Checks["db"].Cached {
+		t.Fatalf("runs = %d, result = %+v", runs.Load(), report.Checks["db"])
+	}
+}
+
+func TestChecker_CallerCancelled(t *testing.T) {
+	var logBuf bytes.Buffer
+	var runs atomic.Int32
+	c := health.NewChecker(slog.New(slog.NewTextHandler(&logBuf, nil)))
+	c.Register("db", func(ctx context.Context) error {
+		runs.Add(1)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, health.WithCacheTTL(time.Minute))
+
+	// an impatient probe gets a failure...
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if r := c.Readiness(ctx).Checks["db"]; r.Status != health.StatusFail {
+		t.Fatalf("abandoned check = %+v", r)
+	}
+	// ...which is neither cached nor logged
+	report := c.Readiness(context.Background())
+	if r := report.Checks["db"]; r.Status != health.StatusPass || r.Cached || runs.Load() != 2 {
+		t.Fatalf("check after an abandoned probe = %+v after %d runs", r, runs.Load())
+	}
+	if strings.Contains(logBuf.String(), "Health check failing") {
+		t.Fatalf("abandoned probe logged as a failure:\n%s", logBuf.String())
+	}
+}
+
+func TestChecker_LogsTransitions(t *testing.T) {
+	var logBuf bytes.Buffer
+	var healthy atomic.Bool
+	c := health.NewChecker(slog.New(slog.NewTextHandler(&logBuf, nil)))
+	c.Register("db", func(context.Context) error {
+		if healthy.Load() {
+			return nil
+		}
+		return errors.New("refused")
+	})
+
+	c.Readiness(context.Background())
+	c.Readiness(context.Background())
+	healthy.Store(true)
+	c.Readiness(context.Background())
+
+	logs := logBuf.String()
+	if strings.Count(logs, `msg="Health check failing" check=db error=refused`) != 1 || strings.Count(logs, `msg="Health check recovered" check=db`) != 1 {
+		t.Fatalf("transitions not logged once:\n%s", logs)
+	}
+}
+
+func TestChecker_Endpoints(t *testing.T) {
+	c := health.NewChecker(slog.New(slog.DiscardHandler))
+	c.Register("db", fail)
+	c.Register("loop", pass, health.WithLiveness())
+	mux := http.NewServeMux()
+	c.Mount(mux)
+
+	for path, want := range map[string]int{
+		health.LivenessPath:  http.StatusOK,
+		health.ReadinessPath: http.StatusServiceUnavailable,
+		health.HealthPath:    http.StatusServiceUnavailable,
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want || rr.Header().Get("Content-Type") != health.ContentType {
+			t.Errorf("%s: %d %q", path, rr.Code, rr.Header().Get("Content-Type"))
+		}
+		var report health.Report
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if _, ok := report.Checks["db"]; ok == (path == health.LivenessPath) {
+			t.Errorf("%s: checks = %v", path, report.Checks)
+		}
+	}
+}
+
+func TestChecker_SetReady(t *testing.T) {
+	c := health.NewChecker(slog.New(slog.DiscardHandler))
+	c.Register("db", pass)
+	c.SetReady(false)
+	report := c.Readiness(context.Background())
+	if report.Status != health.StatusFail || report.Checks[health.NotReadyCheck].Status != health.StatusFail {
+		t.Fatalf("readiness while not ready = %+v", report)
+	}
+	if c.Liveness(context.Background()).Status != health.StatusPass {
+		t.Fatal("liveness affected by SetReady")
+	}
+	c.SetReady(true)
+	if c.Readiness(context.Background()).Status != health.StatusPass {
+		t.Fatal("readiness did not come back")
+	}
+}
@@ -0,0 +1,47 @@
+// Package idempotency stores the responses of requests carrying an
+// Idempotency-Key, so retries of the same request get the same response
+// instead of running twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInFlight is returned by Begin when another request holds the key.
+	ErrInFlight = errors.New("idempotency: request with the same key in flight")
+	// ErrMismatch is returned by Begin when the key was used for a
+	// different request.
+	ErrMismatch = errors.New("idempotency: key reused with a different request")
+	// ErrNotHeld is returned by Complete and Release when the claim is no
+	// longer held, its lease having expired.
+	ErrNotHeld = errors.New("idempotency: key no longer held")
+)
+
+// Record is the response stored for a key.
+type Record struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Created time.Time
+}
+
+// Store keeps the state of idempotency keys.
+type Store interface {
+	// Begin claims key for a request identified by fingerprint, for at most
+	// lease. When the key is free it returns the token of the claim, the
+	// stored record when a request with the same fingerprint completed
+	// already, ErrMismatch when the fingerprint differs and ErrInFlight when
+	// the request holding the key has not completed nor let its lease
+	// expire.
+	Begin(ctx context.Context, key, fingerprint string, lease time.Duration) (token string, rec *Record, err error)
+	// Complete stores the response of the request holding the claim of
+	// token, replayed for ttl.
+	Complete(ctx context.Context, key, token string, rec Record, ttl time.Duration) error
+	// Release frees a claim without storing a response, so the request can
+	// be retried.
+	Release(ctx context.Context, key, token string) error
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key         TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    token       TEXT NOT NULL, -- identifies the request holding the key
+    status      INTEGER NOT NULL DEFAULT 0, -- 0 while the request is in flight
+    header      TEXT NOT NULL DEFAULT '{}',
+    body        BLOB,
+    created     INTEGER NOT NULL,
+    expires     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires ON idempotency_keys (expires);
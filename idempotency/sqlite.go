@@ -0,0 +1,212 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver (pure go)
+
+	"github.com/Guadalsistema/net-utils/database"
+	"github.com/Guadalsistema/net-utils/migrate"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// MigrationsTable is the table recording the migrations of the store, kept
+// apart from the application's own.
+const MigrationsTable = "idempotency_schema_migrations"
+
+// sweepInterval is how often expired keys are deleted.
+const sweepInterval = time.Minute
+
+// SQLiteStore keeps idempotency keys in the idempotency_keys table of a
+// SQLite database, so stored responses survive restarts and are shared by
+// every process using the same file. Claims run in IMMEDIATE transactions,
+// which SQLite serialises across processes.
+type SQLiteStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+	// Clock returns the current time; time.Now when nil.
+	Clock func() time.Time
+}
+
+// OpenSQLiteStore opens the SQLite database of dsn, as accepted by
+// database.ParseDSN, with the pragmas of database.SharedSQLiteDSN, and
+// creates the table through the migrate package. In-memory databases need a
+// shared cache, e.g. "file::memory:?cache=shared".
+func OpenSQLiteStore(ctx context.Context, dsn string) (*SQLiteStore, error) {
+	driver, path := database.ParseDSN(dsn)
+	if driver != "sqlite" {
+		return nil, fmt.Errorf("idempotency: unsupported driver %q", driver)
+	}
+	if database.IsSQLiteMemory(path) && !strings.Contains(path, "cache=shared") {
+		// the migrations run on a connection of their own
+		return nil, fmt.Errorf("idempotency: in-memory databases need cache=shared")
+	}
+	path = database.SharedSQLiteDSN(path)
+	// opened first, so a shared in-memory database outlives the migrations
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("idempotency: opening database: %w", err)
+	}
+	if database.IsSQLiteMemory(path) {
+		// shared cache connections fail on locks instead of waiting
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("idempotency: opening database: %w", err)
+	}
+	// path has a query string by now, from the pragmas
+	databaseURL := database.FormatDSN(driver, path+"&x-migrations-table="+MigrationsTable)
+	if err := migrate.UpFS(databaseURL, migrations, "migrations"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("idempotency: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// DB returns the underlying database.
+func (s *SQLiteStore) DB() *sql.DB { return s.db }
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error { return s.db.Close() }
+
+func (s *SQLiteStore) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+func (s *SQLiteStore) Begin(ctx context.Context, key, fingerprint string, lease time.Duration) (string, *Record, error) {
+	now := s.now()
+	s.sweep(ctx, now)
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("idempotency: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return "", nil, fmt.Errorf("idempotency: begin: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		}
+	}()
+
+	var stored string
+	var status int
+	var header string
+	var body []byte
+	var created, expires int64
+	err = conn.QueryRowContext(ctx, `SELECT fingerprint, status, header, body, created, expires FROM idempotency_keys WHERE key = ?`, key).
+		Scan(&stored, &status, &header, &body, &created, &expires)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return "", nil, fmt.Errorf("idempotency: reading key: %w", err)
+	case now.UnixNano() >= expires:
+		// expired response or abandoned claim: the key is free again
+	case stored != fingerprint:
+		return "", nil, ErrMismatch
+	case status == 0:
+		return "", nil, ErrInFlight
+	default:
+		rec := &Record{Status: status, Body: body, Created: time.Unix(0, created)}
+		if err := json.Unmarshal([]byte(header), &rec.Header); err != nil {
+			return "", nil, fmt.Errorf("idempotency: decoding headers: %w", err)
+		}
+		return "", rec, nil
+	}
+
+	token := utils.RandomKey(24)
+	_, err = conn.ExecContext(ctx, `INSERT INTO idempotency_keys (key, fingerprint, token, status, header, body, created, expires) VALUES (?, ?, ?, 0, '{}', NULL, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET fingerprint = excluded.fingerprint, token = excluded.token, status = 0, header = '{}', body = NULL, created = excluded.created, expires = excluded.expires`,
+		key, fingerprint, token, now.UnixNano(), now.Add(lease).UnixNano())
+	if err != nil {
+		return "", nil, fmt.Errorf("idempotency: claiming key: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return "", nil, fmt.Errorf("idempotency: commit: %w", err)
+	}
+	committed = true
+	return token, nil, nil
+}
+
+func (s *SQLiteStore) Complete(ctx context.Context, key, token string, rec Record, ttl time.Duration) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return fmt.Errorf("idempotency: encoding headers: %w", err)
+	}
+	body := rec.Body
+	if body == nil {
+		body = []byte{}
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE idempotency_keys SET status = ?, header = ?, body = ?, expires = ? WHERE key = ? AND token = ? AND status = 0`,
+		rec.Status, string(header), body, s.now().Add(ttl).UnixNano(), key, token)
+	if err != nil {
+		return fmt.Errorf("idempotency: storing response: %w", err)
+	}
+	return held(res)
+}
+
+func (s *SQLiteStore) Release(ctx context.Context, key, token string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = ? AND token = ? AND status = 0`, key, token)
+	if err != nil {
+		return fmt.Errorf("idempotency: releasing key: %w", err)
+	}
+	return held(res)
+}
+
+// held returns ErrNotHeld when res shows the claim was taken over, or swept,
+// after its lease.
+func held(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("idempotency: %w", err)
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Cleanup deletes expired keys and returns how many there were. It runs
+// periodically on its own; call it to purge the table on demand.
+func (s *SQLiteStore) Cleanup(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires <= ?`, s.now().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("idempotency: cleanup: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// sweep runs Cleanup at most once per sweepInterval.
+func (s *SQLiteStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if due {
+		s.Cleanup(ctx)
+	}
+}
+
+var _ Store = (*SQLiteStore)(nil)
@@ -0,0 +1,167 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/idempotency"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func openStore(t *testing.T, dsn string) (*idempotency.SQLiteStore, *clock) {
+	t.Helper()
+	s, err := idempotency.OpenSQLiteStore(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	c := &clock{now: time.Unix(1_700_000_000, 0)}
+	s.Clock = c.Now
+	return s, c
+}
+
+func TestSQLiteStore_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "idempotency.db")
+	s, _ := openStore(t, dsn)
+
+	token, rec, err := s.Begin(ctx, "k", "fp", time.Minute)
+	if token == "" || rec != nil || err != nil {
+		t.Fatalf("Begin on a new key = %q, %v, %v", token, rec, err)
+	}
+	if _, _, err := s.Begin(ctx, "k", "fp", time.Minute); !errors.Is(err, idempotency.ErrInFlight) {
+		t.Fatalf("Begin while in flight: err = %v, want ErrInFlight", err)
+	}
+	if _, _, err := s.Begin(ctx, "k", "other", time.Minute); !errors.Is(err, idempotency.ErrMismatch) {
+		t.Fatalf("Begin with another fingerprint: err = %v, want ErrMismatch", err)
+	}
+
+	want := idempotency.Record{Status: http.StatusCreated, Header: http.Header{"Location": {"/orders/1"}}, Body: []byte(`{"id":1}`)}
+	if err := s.Complete(ctx, "k", token, want, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored responses survive reopening the database
+	reopened, _ := openStore(t, dsn)
+	_, rec, err = reopened.Begin(ctx, "k", "fp", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil || rec.Status != want.Status || rec.Header.Get("Location") != "/orders/1" || string(rec.Body) != string(want.Body) {
+		t.Fatalf("replayed record = %+v, want %+v", rec, want)
+	}
+	if _, _, err := reopened.Begin(ctx, "k", "other", time.Minute); !errors.Is(err, idempotency.ErrMismatch) {
+		t.Fatalf("Begin with another fingerprint after completion: err = %v, want ErrMismatch", err)
+	}
+}
+
+func TestSQLiteStore_Release(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openStore(t, filepath.Join(t.TempDir(), "idempotency.db"))
+
+	token, _, _ := s.Begin(ctx, "k", "fp", time.Minute)
+	if err := s.Release(ctx, "k", token); err != nil {
+		t.Fatal(err)
+	}
+	if _, rec, err := s.Begin(ctx, "k", "other", time.Minute); rec != nil || err != nil {
+		t.Fatalf("Begin after Release = %v, %v", rec, err)
+	}
+}
+
+func TestSQLiteStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	s, c := openStore(t, filepath.Join(t.TempDir(), "idempotency.db"))
+
+	// an abandoned claim frees the key once its lease is over
+	s.Begin(ctx, "abandoned", "fp", time.Minute)
+	c.Advance(time.Minute)
+	if _, rec, err := s.Begin(ctx, "abandoned", "fp", time.Minute); rec != nil || err != nil {
+		t.Fatalf("Begin after the lease = %v, %v", rec, err)
+	}
+
+	token, _, _ := s.Begin(ctx, "done", "fp", time.Minute)
+	s.Complete(ctx, "done", token, idempotency.Record{Status: http.StatusOK}, time.Hour)
+	c.Advance(time.Hour)
+	if _, rec, err := s.Begin(ctx, "done", "other", time.Minute); rec != nil || err != nil {
+		t.Fatalf("Begin after the TTL = %v, %v", rec, err)
+	}
+
+	// the earlier keys were swept by Begin; the last claim is left
+	c.Advance(time.Minute)
+	n, err := s.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Cleanup deleted %d keys, want 1", n)
+	}
+}
+
+func TestSQLiteStore_StaleClaim(t *testing.T) {
+	ctx := context.Background()
+	s, c := openStore(t, filepath.Join(t.TempDir(), "idempotency.db"))
+
+	// the first request outlives its lease and the key is claimed again
+	stale, _, _ := s.Begin(ctx, "k", "fp", time.Minute)
+	c.Advance(time.Minute)
+	token, _, err := s.Begin(ctx, "k", "fp", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Complete(ctx, "k", stale, idempotency.Record{Status: http.StatusTeapot}, time.Hour); !errors.Is(err, idempotency.ErrNotHeld) {
+		t.Fatalf("stale Complete: err = %v, want ErrNotHeld", err)
+	}
+	if err := s.Release(ctx, "k", stale); !errors.Is(err, idempotency.ErrNotHeld) {
+		t.Fatalf("stale Release: err = %v, want ErrNotHeld", err)
+	}
+	if _, _, err := s.Begin(ctx, "k", "fp", time.Minute); !errors.Is(err, idempotency.ErrInFlight) {
+		t.Fatalf("new claim lost: err = %v, want ErrInFlight", err)
+	}
+	if err := s.Complete(ctx, "k", token, idempotency.Record{Status: http.StatusCreated}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, rec, _ := s.Begin(ctx, "k", "fp", time.Minute); rec == nil || rec.Status != http.StatusCreated {
+		t.Fatalf("replayed record = %+v, want the new claim's response", rec)
+	}
+}
+
+func TestOpenSQLiteStore_Memory(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openStore(t, "file::memory:?cache=shared")
+	if _, _, err := s.Begin(ctx, "k", "fp", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// a private in-memory database would not see the migrated table
+	if _, err := idempotency.OpenSQLiteStore(ctx, ":memory:"); err == nil {
+		t.Fatal("expected an error for :memory:")
+	}
+}
+
+func TestOpenSQLiteStore_UnsupportedDriver(t *testing.T) {
+	if _, err := idempotency.OpenSQLiteStore(context.Background(), "postgres://localhost/db"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,73 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+// TraceHandler wraps a slog.Handler and adds the trace ID, span ID and
+// selected baggage of the record context, so plain slog.InfoContext calls,
+// including those made by third-party libraries, are correlated with the
+// request. Records without a trace are passed through unchanged.
+//
+// Attributes are added to the record, so after WithGroup they are nested in
+// the open group like any other record attribute.
+type TraceHandler struct {
+	handler     slog.Handler
+	baggageKeys []string
+}
+
+// NewTraceHandler wraps h. baggageKeys selects the baggage entries to copy;
+// when none are given the keys set with SetBaggageKeys are used.
+func NewTraceHandler(h slog.Handler, baggageKeys ...string) *TraceHandler {
+	return &TraceHandler{handler: h, baggageKeys: baggageKeys}
+}
+
+func (h *TraceHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *TraceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil || hasAttr(r, "trace") { // already added by the Context* helpers
+		return h.handler.Handle(ctx, r)
+	}
+	r = r.Clone()
+	if traceId, ok := trace.TraceIdFrom(ctx); ok {
+		r.AddAttrs(slog.String("trace", traceId))
+	}
+	if sc, ok := trace.SpanContextFrom(ctx); ok {
+		r.AddAttrs(slog.String("span", sc.SpanID.String()))
+	}
+	keys := h.baggageKeys
+	if len(keys) == 0 {
+		if global := baggageKeys.Load(); global != nil {
+			keys = *global
+		}
+	}
+	if attr, ok := baggageAttr(ctx, keys); ok {
+		r.AddAttrs(attr)
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *TraceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &TraceHandler{handler: h.handler.WithAttrs(attrs), baggageKeys: h.baggageKeys}
+}
+
+func (h *TraceHandler) WithGroup(name string) slog.Handler {
+	return &TraceHandler{handler: h.handler.WithGroup(name), baggageKeys: h.baggageKeys}
+}
+
+// Unwrap returns the wrapped handler.
+func (h *TraceHandler) Unwrap() slog.Handler { return h.handler }
+
+func hasAttr(r slog.Record, key string) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		found = a.Key == key
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,64 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestTraceHandler(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(log.NewTraceHandler(slog.NewTextHandler(&logBuf, nil), "tenant"))
+
+	bag, _ := trace.ParseBaggage("tenant=acme")
+	ctx, span := trace.Start(trace.WithBaggage(context.Background(), bag), "op")
+	sc := span.SpanContext()
+
+	logger.InfoContext(ctx, "plain slog call", "k", "v")
+
+	output := logBuf.String()
+	for _, want := range []string{"plain slog call", "k=v", "trace=" + sc.TraceID.String(), "span=" + sc.SpanID.String(), "baggage.tenant=acme"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in %s", want, output)
+		}
+	}
+}
+
+func TestTraceHandlerWithoutTrace(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(log.NewTraceHandler(slog.NewTextHandler(&logBuf, nil)))
+
+	logger.InfoContext(context.Background(), "no trace")
+	logger.Info("no context")
+
+	output := logBuf.String()
+	if strings.Count(output, "\n") != 2 {
+		t.Fatalf("expected both records to be logged, got: %s", output)
+	}
+	if strings.Contains(output, "trace=") || strings.Contains(output, "span=") {
+		t.Errorf("missing trace IDs should be omitted, got: %s", output)
+	}
+}
+
+func TestTraceHandlerDoesNotDuplicate(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(log.NewTraceHandler(slog.NewTextHandler(&logBuf, nil)).WithAttrs([]slog.Attr{slog.String("svc", "api")}))
+
+	ctx, _ := trace.Start(context.Background(), "op")
+	if err := log.ContextInfo(logger, ctx, "helper call"); err != nil {
+		t.Fatalf("ContextInfo failed: %v", err)
+	}
+
+	output := logBuf.String()
+	if strings.Count(output, "trace=") != 1 || strings.Count(output, "span=") != 1 {
+		t.Errorf("trace attributes duplicated: %s", output)
+	}
+	if !strings.Contains(output, "svc=api") {
+		t.Errorf("WithAttrs lost: %s", output)
+	}
+}
@@ -8,13 +8,22 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Guadalsistema/net-utils/redact"
 	"github.com/Guadalsistema/net-utils/trace"
 )
 
-// LogHeaders turns http.Header into a slog.Group("headers", …)
+// LogHeaders turns http.Header into a slog.Group("headers", …), masking
+// sensitive headers according to redact.Default.
 func LogHeaders(h http.Header) slog.Attr {
+	return LogHeadersWith(redact.Default(), h)
+}
+
+// LogHeadersWith is LogHeaders with an explicit redaction policy.
+func LogHeadersWith(p *redact.Policy, h http.Header) slog.Attr {
+	h = p.Header(h)
 	var args []any
 	var keys []string
 	for k := range h {
@@ -27,7 +36,37 @@ func LogHeaders(h http.Header) slog.Attr {
 	return slog.Group("headers", args...)
 }
 
-func logContext(l *slog.Logger, ctx context.Context, level slog.Level, msg string, args ...any) error {
+var baggageKeys atomic.Pointer[[]string]
+
+// SetBaggageKeys selects the baggage entries (see trace.BaggageFrom) copied
+// onto every record logged through the Context* helpers, grouped under
+// "baggage". Calling it without keys disables the copy.
+func SetBaggageKeys(keys ...string) {
+	keys = append([]string(nil), keys...)
+	baggageKeys.Store(&keys)
+}
+
+// baggageAttr returns the selected baggage entries of ctx as a group.
+func baggageAttr(ctx context.Context, keys []string) (slog.Attr, bool) {
+	if len(keys) == 0 {
+		return slog.Attr{}, false
+	}
+	bag := trace.BaggageFrom(ctx)
+	var args []any
+	for _, k := range keys {
+		if v, ok := bag.Get(k); ok {
+			args = append(args, k, v)
+		}
+	}
+	if len(args) == 0 {
+		return slog.Attr{}, false
+	}
+	return slog.Group("baggage", args...), true
+}
+
+// logContext logs with the trace of ctx. Without one it fails, unless
+// optional is set, in which case the record goes out without it.
+func logContext(l *slog.Logger, ctx context.Context, level slog.Level, optional bool, msg string, args ...any) error {
 	if !l.Enabled(ctx, level) {
 		return nil
 	}
@@ -35,13 +74,22 @@ func logContext(l *slog.Logger, ctx context.Context, level slog.Level, msg strin
 	runtime.Callers(3, pcs[:])
 	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
 
-	trace, ok := trace.TraceIdFrom(ctx)
-	if !ok {
+	traceId, ok := trace.TraceIdFrom(ctx)
+	switch {
+	case ok:
+		r.Add("trace", traceId)
+		if sc, ok := trace.SpanContextFrom(ctx); ok {
+			r.Add("span", sc.SpanID.String())
+		}
+		if keys := baggageKeys.Load(); keys != nil {
+			if attr, ok := baggageAttr(ctx, *keys); ok {
+				r.AddAttrs(attr)
+			}
+		}
+	case !optional:
 		return fmt.Errorf("context does not contain trace ID")
 	}
 
-	r.Add("trace", trace)
-
 	r.Add(args...)
 	err := l.Handler().Handle(ctx, r)
 	if err != nil {
@@ -50,17 +98,28 @@ func logContext(l *slog.Logger, ctx context.Context, level slog.Level, msg strin
 	return nil
 }
 func ContextInfo(l *slog.Logger, ctx context.Context, msg string, args ...any) error {
-	return logContext(l, ctx, slog.LevelInfo, msg, args...)
+	return logContext(l, ctx, slog.LevelInfo, false, msg, args...)
 }
 
 func ContextWarning(l *slog.Logger, ctx context.Context, msg string, args ...any) error {
-	return logContext(l, ctx, slog.LevelWarn, msg, args...)
+	return logContext(l, ctx, slog.LevelWarn, false, msg, args...)
 }
 
 func ContextError(l *slog.Logger, ctx context.Context, msg string, args ...any) error {
-	return logContext(l, ctx, slog.LevelError, msg, args...)
+	return logContext(l, ctx, slog.LevelError, false, msg, args...)
 }
 
 func ContextDebug(l *slog.Logger, ctx context.Context, msg string, args ...any) error {
-	return logContext(l, ctx, slog.LevelDebug, msg, args...)
+	return logContext(l, ctx, slog.LevelDebug, false, msg, args...)
+}
+
+// ContextLog logs at an arbitrary level, for callers that pick the level at runtime.
+func ContextLog(l *slog.Logger, ctx context.Context, level slog.Level, msg string, args ...any) error {
+	return logContext(l, ctx, level, false, msg, args...)
+}
+
+// Log logs at level with the trace of ctx when there is one, and as a plain
+// record otherwise, e.g. for requests outside TraceMiddleware.
+func Log(l *slog.Logger, ctx context.Context, level slog.Level, msg string, args ...any) error {
+	return logContext(l, ctx, level, true, msg, args...)
 }
@@ -40,3 +40,60 @@ func TestInfoContext(t *testing.T) {
 		t.Errorf("expected log to contain 'key1=value1', got: %s", output)
 	}
 }
+
+func TestInfoContextWithSpan(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	ctx, span := trace.Start(context.Background(), "op")
+	log.ContextInfo(logger, ctx, "Test message")
+
+	sc := span.SpanContext()
+	if !bytes.Contains(logBuf.Bytes(), []byte("trace="+sc.TraceID.String())) {
+		t.Errorf("expected log to contain the trace ID, got: %s", logBuf.String())
+	}
+	if !bytes.Contains(logBuf.Bytes(), []byte("span="+sc.SpanID.String())) {
+		t.Errorf("expected log to contain the span ID, got: %s", logBuf.String())
+	}
+}
+
+func TestInfoContextWithBaggage(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	log.SetBaggageKeys("tenant", "user")
+	t.Cleanup(func() { log.SetBaggageKeys() })
+
+	bag, _ := trace.ParseBaggage("tenant=acme,secret=x")
+	ctx := trace.WithBaggage(trace.WithTraceId(context.Background(), "123-abc"), bag)
+	log.ContextInfo(logger, ctx, "Test message")
+
+	output := logBuf.String()
+	if !bytes.Contains(logBuf.Bytes(), []byte("baggage.tenant=acme")) {
+		t.Errorf("expected selected baggage in log, got: %s", output)
+	}
+	if bytes.Contains(logBuf.Bytes(), []byte("secret")) {
+		t.Errorf("unselected baggage must not be logged, got: %s", output)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{AddSource: true}))
+
+	if err := log.Log(logger, context.Background(), slog.LevelWarn, "No trace", "key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	log.Log(logger, trace.WithTraceId(context.Background(), "123-abc"), slog.LevelWarn, "Traced")
+
+	output := logBuf.String()
+	if !bytes.Contains(logBuf.Bytes(), []byte(`level=WARN source=`)) || !bytes.Contains(logBuf.Bytes(), []byte("log_test.go")) {
+		t.Errorf("expected the caller as source, got: %s", output)
+	}
+	if !bytes.Contains(logBuf.Bytes(), []byte(`msg="No trace" key1=value1`)) {
+		t.Errorf("expected a plain record without trace, got: %s", output)
+	}
+	if !bytes.Contains(logBuf.Bytes(), []byte(`msg=Traced trace=123-abc`)) {
+		t.Errorf("expected the trace on the traced record, got: %s", output)
+	}
+}
@@ -0,0 +1,187 @@
+// Package metrics implements counters, gauges and histograms with labels, and
+// serves them in the Prometheus text exposition format without depending on
+// the Prometheus client library.
+package metrics
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to
+// request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, the first one start and every
+// following one factor times the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	if start <= 0 || factor <= 1 || count < 1 {
+		panic("metrics: ExponentialBuckets needs start > 0, factor > 1 and count >= 1")
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// family holds the series of a metric, one per combination of label values.
+type family[S any] struct {
+	name, help, kind string
+	labels           []string
+	newSeries        func() *S
+	buckets          []float64 // upper bounds, histograms only
+
+	mu     sync.RWMutex
+	series map[string]*series[S]
+}
+
+type series[S any] struct {
+	values []string
+	s      *S
+}
+
+func (f *family[S]) get(values []string) *S {
+	if len(values) != len(f.labels) {
+		panic("metrics: " + f.name + " takes " + strings.Join(f.labels, ", ") + " label values")
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s.s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s.s
+	}
+	s = &series[S]{values: slices.Clone(values), s: f.newSeries()}
+	f.series[key] = s
+	return s.s
+}
+
+// each calls fn for every series, sorted by label values.
+func (f *family[S]) each(fn func(values []string, s *S)) {
+	f.mu.RLock()
+	all := make([]*series[S], 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return slices.Compare(all[i].values, all[j].values) < 0 })
+	for _, s := range all {
+		fn(s.values, s.s)
+	}
+}
+
+func (f *family[S]) describe() (name, help, kind string, labels []string) {
+	return f.name, f.help, f.kind, f.labels
+}
+
+// value is a float64 updated atomically.
+type value struct{ bits atomic.Uint64 }
+
+func (v *value) Load() float64 { return math.Float64frombits(v.bits.Load()) }
+
+func (v *value) Store(f float64) { v.bits.Store(math.Float64bits(f)) }
+
+func (v *value) Add(f float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+f)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	f *family[value]
+}
+
+// Inc adds one to the series of labelValues.
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds v, which must not be negative, to the series of labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.f.get(labelValues).Add(v)
+}
+
+// Value returns the value of the series of labelValues.
+func (c *Counter) Value(labelValues ...string) float64 { return c.f.get(labelValues).Load() }
+
+// Gauge is a value that goes up and down, such as a number of requests in
+// flight.
+type Gauge struct {
+	f *family[value]
+}
+
+// Set sets the series of labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) { g.f.get(labelValues).Store(v) }
+
+// Add adds v, possibly negative, to the series of labelValues.
+func (g *Gauge) Add(v float64, labelValues ...string) { g.f.get(labelValues).Add(v) }
+
+// Inc adds one to the series of labelValues.
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+
+// Dec subtracts one from the series of labelValues.
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+// Value returns the value of the series of labelValues.
+func (g *Gauge) Value(labelValues ...string) float64 { return g.f.get(labelValues).Load() }
+
+// gaugeFunc is a gauge without labels whose value is read when collected.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) describe() (name, help, kind string, labels []string) {
+	return g.name, g.help, "gaugefunc", nil
+}
+
+// histogramSeries holds the observations of one series. counts are per
+// bucket, not cumulative; the last one is the +Inf bucket.
+type histogramSeries struct {
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations, such as latencies, into buckets.
+type Histogram struct {
+	f       *family[histogramSeries]
+	buckets []float64
+}
+
+// Observe records v in the series of labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.f.get(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with v <= upper bound
+	s.mu.Lock()
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	s.mu.Unlock()
+}
+
+// Count returns the number of observations and their sum in the series of
+// labelValues.
+func (h *Histogram) Count(labelValues ...string) (count uint64, sum float64) {
+	s := h.f.get(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.sum
+}
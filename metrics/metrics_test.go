@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/metrics"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := reg.Counter("jobs_total", "Jobs run.", "queue")
+	g := reg.Gauge("jobs_running", "Jobs running.")
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc("mail")
+			g.Inc()
+			g.Dec()
+		}()
+	}
+	wg.Wait()
+	c.Add(0.5, "mail")
+	if v := c.Value("mail"); v != 100.5 {
+		t.Fatalf("counter = %v", v)
+	}
+	if v := c.Value("sms"); v != 0 {
+		t.Fatalf("untouched series = %v", v)
+	}
+	g.Set(3)
+	g.Add(-1)
+	if v := g.Value(); v != 2 {
+		t.Fatalf("gauge = %v", v)
+	}
+}
+
+func TestCounterMisuse(t *testing.T) {
+	c := metrics.NewRegistry().Counter("jobs_total", "Jobs run.", "queue")
+	for name, fn := range map[string]func(){
+		"negative":     func() { c.Add(-1, "mail") },
+		"label values": func() { c.Inc() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := metrics.NewRegistry().Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "read")
+	}
+	count, sum := h.Count("read")
+	if count != 4 || sum != 3.65 {
+		t.Fatalf("count = %d, sum = %v", count, sum)
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	b := metrics.ExponentialBuckets(1, 2, 4)
+	if len(b) != 4 || b[0] != 1 || b[3] != 8 {
+		t.Fatalf("buckets = %v", b)
+	}
+}
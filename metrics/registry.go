@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	nameRE  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type collector interface {
+	describe() (name, help, kind string, labels []string)
+}
+
+// Registry holds metrics by name and exposes them.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]collector)}
+}
+
+// DefaultRegistry is the registry used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+// register returns the metric already registered under name when it has the
+// same kind and labels, and registers the one built by create otherwise.
+// Invalid names and conflicting registrations are programming errors and
+// panic.
+func register[C collector](r *Registry, name, kind string, labels []string, create func() C) C {
+	if !nameRE.MatchString(name) {
+		panic("metrics: invalid metric name " + strconv.Quote(name))
+	}
+	for _, l := range labels {
+		if !labelRE.MatchString(l) || strings.HasPrefix(l, "__") || kind == "histogram" && l == "le" {
+			panic("metrics: invalid label name " + strconv.Quote(l) + " for " + name)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		_, _, k, ls := existing.describe()
+		if c, ok := existing.(C); ok && k == kind && slices.Equal(ls, labels) {
+			return c
+		}
+		panic("metrics: " + name + " already registered with another kind or labels")
+	}
+	c := create()
+	r.metrics[name] = c
+	return c
+}
+
+func newFamily[S any](name, help, kind string, labels []string, newSeries func() *S) *family[S] {
+	return &family[S]{
+		name: name, help: help, kind: kind,
+		labels:    slices.Clone(labels),
+		newSeries: newSeries,
+		series:    make(map[string]*series[S]),
+	}
+}
+
+// Counter returns the counter registered under name, creating it when
+// needed.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{f: register(r, name, "counter", labels, func() *family[value] {
+		return newFamily(name, help, "counter", labels, func() *value { return new(value) })
+	})}
+}
+
+// Gauge returns the gauge registered under name, creating it when needed.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: register(r, name, "gauge", labels, func() *family[value] {
+		return newFamily(name, help, "gauge", labels, func() *value { return new(value) })
+	})}
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn at every
+// collection, such as the size of a pool. Registering the name again keeps
+// the first fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	register(r, name, "gaugefunc", nil, func() *gaugeFunc {
+		return &gaugeFunc{name: name, help: help, fn: fn}
+	})
+}
+
+// Histogram returns the histogram registered under name, creating it with
+// buckets, DefBuckets when nil, when needed. An existing histogram keeps its
+// buckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], 1) {
+		buckets = buckets[:n-1] // +Inf is always there
+	}
+	f := register(r, name, "histogram", labels, func() *family[histogramSeries] {
+		f := newFamily(name, help, "histogram", labels, func() *histogramSeries {
+			return &histogramSeries{counts: make([]uint64, len(buckets)+1)}
+		})
+		f.buckets = buckets
+		return f
+	})
+	return &Histogram{f: f, buckets: f.buckets}
+}
+
+// NewCounter returns a counter of DefaultRegistry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return DefaultRegistry.Counter(name, help, labels...)
+}
+
+// NewGauge returns a gauge of DefaultRegistry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return DefaultRegistry.Gauge(name, help, labels...)
+}
+
+// NewHistogram returns a histogram of DefaultRegistry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.Histogram(name, help, buckets, labels...)
+}
+
+// WriteText writes every metric in the text exposition format, sorted by
+// name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	all := make([]collector, 0, len(r.metrics))
+	for _, c := range r.metrics {
+		all = append(all, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		a, _, _, _ := all[i].describe()
+		b, _, _, _ := all[j].describe()
+		return a < b
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, c := range all {
+		name, help, kind, labels := c.describe()
+		if kind == "gaugefunc" {
+			kind = "gauge"
+		}
+		bw.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+		bw.WriteString("# TYPE " + name + " " + kind + "\n")
+		switch m := c.(type) {
+		case *gaugeFunc:
+			writeSample(bw, name, nil, nil, m.fn())
+		case *family[value]:
+			m.each(func(values []string, v *value) {
+				writeSample(bw, name, labels, values, v.Load())
+			})
+		case *family[histogramSeries]:
+			m.each(func(values []string, s *histogramSeries) {
+				s.mu.Lock()
+				counts, sum, count := slices.Clone(s.counts), s.sum, s.count
+				s.mu.Unlock()
+				bucketLabels := append(slices.Clone(labels), "le")
+				var cumulative uint64
+				for i, upper := range m.buckets {
+					cumulative += counts[i]
+					writeSample(bw, name+"_bucket", bucketLabels, append(slices.Clone(values), formatFloat(upper)), float64(cumulative))
+				}
+				writeSample(bw, name+"_bucket", bucketLabels, append(slices.Clone(values), "+Inf"), float64(count))
+				writeSample(bw, name+"_sum", labels, values, sum)
+				writeSample(bw, name+"_count", labels, values, float64(count))
+			})
+		}
+	}
+	return bw.Flush()
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler serves the metrics of r in the text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+		r.WriteText(w)
+	})
+}
+
+// Handler serves the metrics of DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
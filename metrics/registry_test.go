@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/metrics"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Counter("requests_total", "Requests\nserved \\ total.", "path").Inc(`/a"b`)
+	reg.Counter("requests_total", "ignored", "path").Add(2, "/")
+	reg.GaugeFunc("pool_size", "Pool size.", func() float64 { return 7 })
+	h := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b strings.Builder
+	if err := reg.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.55
+latency_seconds_count 3
+# HELP pool_size Pool size.
+# TYPE pool_size gauge
+pool_size 7
+# HELP requests_total Requests\nserved \\ total.
+# TYPE requests_total counter
+requests_total{path="/"} 2
+requests_total{path="/a\"b"} 1
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_Conflicts(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Counter("hits_total", "Hits.", "path")
+	for name, fn := range map[string]func(){
+		"kind":         func() { reg.Gauge("hits_total", "Hits.", "path") },
+		"labels":       func() { reg.Counter("hits_total", "Hits.", "route") },
+		"metric name":  func() { reg.Counter("hits-total", "Hits.") },
+		"label name":   func() { reg.Counter("misses_total", "Misses.", "__path") },
+		"le in labels": func() { reg.Histogram("size_bytes", "Size.", nil, "le") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Gauge("up", "Up.").Set(1)
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Header().Get("Content-Type") != metrics.ContentType || !strings.Contains(rr.Body.String(), "\nup 1\n") {
+		t.Fatalf("got %q %q", rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+}
@@ -0,0 +1,347 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// Apache style access log formats.
+const (
+	CommonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedLogFormat = CommonLogFormat + ` "%{Referer}i" "%{User-Agent}i"`
+)
+
+// accessEntry holds what is known about a request once it is served.
+type accessEntry struct {
+	r       *http.Request
+	header  http.Header // response headers
+	status  int
+	size    int64
+	start   time.Time
+	elapsed time.Duration
+	traceId string
+	spanId  string
+	policy  *redact.Policy // redacts the URL and request headers
+}
+
+// AccessLogOption configures AccessLog and ECSAccessLog.
+type AccessLogOption func(*accessLogConfig)
+
+type accessLogConfig struct {
+	redaction *redact.Policy
+}
+
+// WithAccessLogRedaction sets the policy applied to the logged URLs and
+// request headers. redact.Default is used when unset.
+func WithAccessLogRedaction(p *redact.Policy) AccessLogOption {
+	return func(c *accessLogConfig) { c.redaction = p }
+}
+
+func newAccessLogConfig(opts []AccessLogOption) *accessLogConfig {
+	cfg := &accessLogConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (c *accessLogConfig) policy() *redact.Policy {
+	if c.redaction != nil {
+		return c.redaction
+	}
+	return redact.Default()
+}
+
+// AccessLogFormat is a compiled access log template.
+//
+// It understands the Apache mod_log_config directives %h, %l, %u, %t, %r,
+// %s, %>s, %b, %B, %D, %T, %m, %U, %q, %H, %{Name}i, %{Name}o and %%, plus
+// %{trace_id}x and %{span_id}x for the trace of the request. %h is the
+// address resolved by ClientIP when it runs in front.
+type AccessLogFormat struct {
+	parts []func(*strings.Builder, *accessEntry)
+}
+
+// ParseAccessLogFormat compiles an access log template.
+func ParseAccessLogFormat(format string) (*AccessLogFormat, error) {
+	f := &AccessLogFormat{}
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '%')
+		if i < 0 {
+			f.literal(format)
+			break
+		}
+		if i > 0 {
+			f.literal(format[:i])
+		}
+		format = format[i+1:]
+		if format == "" {
+			return nil, fmt.Errorf("access log format: trailing %%")
+		}
+		arg := ""
+		if format[0] == '{' {
+			end := strings.IndexByte(format, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("access log format: unterminated %%{")
+			}
+			arg, format = format[1:end], format[end+1:]
+		}
+		format = strings.TrimPrefix(format, ">") // final status is the only status we know
+		if format == "" {
+			return nil, fmt.Errorf("access log format: missing directive")
+		}
+		part, err := accessDirective(format[0], arg)
+		if err != nil {
+			return nil, err
+		}
+		f.parts = append(f.parts, part)
+		format = format[1:]
+	}
+	return f, nil
+}
+
+// MustParseAccessLogFormat is like ParseAccessLogFormat but panics on error.
+func MustParseAccessLogFormat(format string) *AccessLogFormat {
+	f, err := ParseAccessLogFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *AccessLogFormat) literal(s string) {
+	f.parts = append(f.parts, func(b *strings.Builder, _ *accessEntry) { b.WriteString(s) })
+}
+
+func accessDirective(c byte, arg string) (func(*strings.Builder, *accessEntry), error) {
+	switch c {
+	case '%':
+		return func(b *strings.Builder, _ *accessEntry) { b.WriteByte('%') }, nil
+	case 'h':
+		return func(b *strings.Builder, e *accessEntry) { b.WriteString(ClientAddress(e.r)) }, nil
+	case 'l':
+		return func(b *strings.Builder, _ *accessEntry) { b.WriteByte('-') }, nil
+	case 'u':
+		return func(b *strings.Builder, e *accessEntry) {
+			user, _, ok := e.r.BasicAuth()
+			writeField(b, user, ok && user != "")
+		}, nil
+	case 't':
+		return func(b *strings.Builder, e *accessEntry) {
+			b.WriteString(e.start.Format("[02/Jan/2006:15:04:05 -0700]"))
+		}, nil
+	case 'r':
+		return func(b *strings.Builder, e *accessEntry) { writeEscaped(b, e.requestLine()) }, nil
+	case 's':
+		return func(b *strings.Builder, e *accessEntry) { b.WriteString(strconv.Itoa(e.status)) }, nil
+	case 'b':
+		return func(b *strings.Builder, e *accessEntry) {
+			writeField(b, strconv.FormatInt(e.size, 10), e.size > 0)
+		}, nil
+	case 'B':
+		return func(b *strings.Builder, e *accessEntry) { b.WriteString(strconv.FormatInt(e.size, 10)) }, nil
+	case 'D':
+		return func(b *strings.Builder, e *accessEntry) {
+			b.WriteString(strconv.FormatInt(e.elapsed.Microseconds(), 10))
+		}, nil
+	case 'T':
+		return func(b *strings.Builder, e *accessEntry) {
+			b.WriteString(strconv.FormatInt(int64(e.elapsed/time.Second), 10))
+		}, nil
+	case 'm':
+		return func(b *strings.Builder, e *accessEntry) { b.WriteString(e.r.Method) }, nil
+	case 'U':
+		return func(b *strings.Builder, e *accessEntry) { writeEscaped(b, e.r.URL.Path) }, nil
+	case 'q':
+		return func(b *strings.Builder, e *accessEntry) {
+			if u := e.policy.URL(e.r.URL); strings.Contains(u, "?") {
+				writeEscaped(b, u[strings.IndexByte(u, '?'):])
+			}
+		}, nil
+	case 'H':
+		return func(b *strings.Builder, e *accessEntry) { b.WriteString(e.r.Proto) }, nil
+	case 'i', 'o':
+		if arg == "" {
+			return nil, fmt.Errorf("access log format: %%%c needs a header name", c)
+		}
+		name := http.CanonicalHeaderKey(arg)
+		if c == 'i' {
+			return func(b *strings.Builder, e *accessEntry) {
+				v := e.policy.Header(http.Header{name: e.r.Header.Values(name)}).Get(name)
+				writeField(b, v, v != "")
+			}, nil
+		}
+		return func(b *strings.Builder, e *accessEntry) {
+			v := e.header.Get(name)
+			writeField(b, v, v != "")
+		}, nil
+	case 'x':
+		switch arg {
+		case "trace_id":
+			return func(b *strings.Builder, e *accessEntry) { writeField(b, e.traceId, e.traceId != "") }, nil
+		case "span_id":
+			return func(b *strings.Builder, e *accessEntry) { writeField(b, e.spanId, e.spanId != "") }, nil
+		}
+		return nil, fmt.Errorf("access log format: unknown %%{%s}x", arg)
+	}
+	return nil, fmt.Errorf("access log format: unknown directive %%%c", c)
+}
+
+func (f *AccessLogFormat) format(e *accessEntry) string {
+	var b strings.Builder
+	for _, part := range f.parts {
+		part(&b, e)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// writeField writes v escaped, or "-" when it is absent.
+func writeField(b *strings.Builder, v string, ok bool) {
+	if !ok {
+		b.WriteByte('-')
+		return
+	}
+	writeEscaped(b, v)
+}
+
+// writeEscaped escapes quotes, backslashes and control characters the way
+// Apache does, so a field cannot break the line format.
+func writeEscaped(b *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (e *accessEntry) requestLine() string {
+	return e.r.Method + " " + e.policy.URL(e.r.URL) + " " + e.r.Proto
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// serveAccess serves the request and collects the access log entry. The
+// trace is read from the request context when the access log runs inside
+// TraceMiddleware, or from the echoed response headers when it runs outside.
+func (c *accessLogConfig) serveAccess(next http.Handler, w http.ResponseWriter, r *http.Request) *accessEntry {
+	start := time.Now()
+	resp := &utils.ResponseRecorder{ResponseWriter: w, Status: http.StatusOK, Limit: -1}
+	next.ServeHTTP(resp, r)
+
+	e := &accessEntry{r: r, header: resp.Header(), status: resp.Status, size: resp.Size, start: start, elapsed: time.Since(start), policy: c.policy()}
+	if sc, ok := trace.SpanContextFrom(r.Context()); ok {
+		e.traceId, e.spanId = sc.TraceID.String(), sc.SpanID.String()
+	} else if sc, err := trace.ParseTraceparent(resp.Header().Get(trace.TraceparentHeader)); err == nil {
+		e.traceId, e.spanId = sc.TraceID.String(), sc.SpanID.String()
+	}
+	if e.traceId == "" {
+		// legacy transaction ID only
+		if id, ok := trace.TraceIdFrom(r.Context()); ok {
+			e.traceId = id
+		} else {
+			e.traceId = resp.Header().Get(trace.TxIdHeader)
+		}
+	}
+	return e
+}
+
+// AccessLog returns a middleware writing one line per request to w in the
+// given format, e.g. MustParseAccessLogFormat(CombinedLogFormat).
+func AccessLog(w io.Writer, f *AccessLogFormat, opts ...AccessLogOption) func(http.Handler) http.Handler {
+	cfg := newAccessLogConfig(opts)
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			e := cfg.serveAccess(next, rw, r)
+			line := f.format(e)
+			mu.Lock()
+			defer mu.Unlock()
+			io.WriteString(w, line)
+		})
+	}
+}
+
+// ECSAccessLog returns a middleware logging one record per request to l with
+// attributes shaped after the Elastic Common Schema (http.*, url.*,
+// client.*, user_agent.*, event.duration, trace.id, span.id).
+func ECSAccessLog(l *slog.Logger, opts ...AccessLogOption) func(http.Handler) http.Handler {
+	cfg := newAccessLogConfig(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e := cfg.serveAccess(next, w, r)
+			l.LogAttrs(r.Context(), slog.LevelInfo, e.requestLine(), ecsAttrs(e)...)
+		})
+	}
+}
+
+func ecsAttrs(e *accessEntry) []slog.Attr {
+	r := e.r
+	request := []any{
+		slog.String("method", r.Method),
+		slog.Group("body", slog.Int64("bytes", max(r.ContentLength, 0))),
+	}
+	if ref := r.Referer(); ref != "" {
+		request = append(request, slog.String("referrer", ref))
+	}
+	u := e.policy.URL(r.URL)
+	urlAttrs := []any{
+		slog.String("original", u),
+		slog.String("path", r.URL.Path),
+	}
+	if strings.Contains(u, "?") {
+		urlAttrs = append(urlAttrs, slog.String("query", u[strings.IndexByte(u, '?')+1:]))
+	}
+	attrs := []slog.Attr{
+		slog.Group("http",
+			slog.String("version", strings.TrimPrefix(r.Proto, "HTTP/")),
+			slog.Group("request", request...),
+			slog.Group("response",
+				slog.Int("status_code", e.status),
+				slog.Group("body", slog.Int64("bytes", e.size)),
+			),
+		),
+		slog.Group("url", urlAttrs...),
+		slog.Group("client", slog.String("address", ClientAddress(r))),
+		slog.Group("user_agent", slog.String("original", r.UserAgent())),
+		slog.Group("event",
+			slog.String("kind", "event"),
+			slog.String("category", "web"),
+			slog.Int64("duration", e.elapsed.Nanoseconds()),
+			slog.Time("start", e.start),
+		),
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		attrs = append(attrs, slog.Group("user", slog.String("name", user)))
+	}
+	if e.traceId != "" {
+		attrs = append(attrs, slog.Group("trace", slog.String("id", e.traceId)))
+	}
+	if e.spanId != "" {
+		attrs = append(attrs, slog.Group("span", slog.String("id", e.spanId)))
+	}
+	return attrs
+}
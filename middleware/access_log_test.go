@@ -0,0 +1,148 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func hello(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("hello"))
+}
+
+func TestAccessLog_Combined(t *testing.T) {
+	var out bytes.Buffer
+	h := middleware.AccessLog(&out, middleware.MustParseAccessLogFormat(middleware.CombinedLogFormat))(http.HandlerFunc(hello))
+
+	req := httptest.NewRequest(http.MethodPost, "/items?x=1", nil)
+	req.RemoteAddr = "192.0.2.7:5555"
+	req.SetBasicAuth("ana", "secret")
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", `curl/8 "quoted"`)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	re := regexp.MustCompile(`^192\.0\.2\.7 - ana \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /items\?x=1 HTTP/1\.1" 201 5 "https://example\.com/" "curl/8 \\"quoted\\""\n$`)
+	if !re.MatchString(out.String()) {
+		t.Fatalf("unexpected line: %q", out.String())
+	}
+}
+
+func TestAccessLog_CommonEmptyBody(t *testing.T) {
+	var out bytes.Buffer
+	h := middleware.AccessLog(&out, middleware.MustParseAccessLogFormat(middleware.CommonLogFormat))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/items/1", nil))
+
+	if !strings.HasSuffix(out.String(), `"DELETE /items/1 HTTP/1.1" 204 -`+"\n") {
+		t.Fatalf("unexpected line: %q", out.String())
+	}
+}
+
+func TestAccessLog_CustomTemplateWithTrace(t *testing.T) {
+	var out bytes.Buffer
+	f, err := middleware.ParseAccessLogFormat(`%m %U%q %s %B %{trace_id}x %{Content-Type}o 100%%`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var traceId string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, _ := trace.SpanContextFrom(r.Context())
+		traceId = sc.TraceID.String()
+		w.Header().Set("Content-Type", "text/plain")
+		hello(w, r)
+	})
+	// outside TraceMiddleware: the trace comes from the response headers
+	h := middleware.AccessLog(&out, f)(middleware.TraceMiddleware(slog.New(slog.DiscardHandler))(next))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a?b=c", nil))
+
+	want := "GET /a?b=c 201 5 " + traceId + " text/plain 100%\n"
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestAccessLog_Redaction(t *testing.T) {
+	policy := &redact.Policy{QueryParams: []string{"ticket"}, DenyHeaders: []string{"X-Ticket"}, Mask: "***"}
+	req := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/a?ticket=abc", nil)
+		r.Header.Set("X-Ticket", "abc")
+		return r
+	}
+
+	var out bytes.Buffer
+	h := middleware.AccessLog(&out, middleware.MustParseAccessLogFormat(`"%r" %q %{X-Ticket}i`),
+		middleware.WithAccessLogRedaction(policy))(http.HandlerFunc(hello))
+	h.ServeHTTP(httptest.NewRecorder(), req())
+	if want := `"GET /a?ticket=%2A%2A%2A HTTP/1.1" ?ticket=%2A%2A%2A ***` + "\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	h = middleware.ECSAccessLog(slog.New(slog.NewJSONHandler(&out, nil)), middleware.WithAccessLogRedaction(policy))(http.HandlerFunc(hello))
+	h.ServeHTTP(httptest.NewRecorder(), req())
+	if strings.Contains(out.String(), "abc") || !strings.Contains(out.String(), `"original":"/a?ticket=%2A%2A%2A"`) {
+		t.Fatalf("unexpected record: %s", out.String())
+	}
+}
+
+func TestParseAccessLogFormat_Errors(t *testing.T) {
+	for _, format := range []string{"%", "%{Referer", "%Z", "%{}i", "%{nope}x"} {
+		if _, err := middleware.ParseAccessLogFormat(format); err == nil {
+			t.Errorf("%q: expected an error", format)
+		}
+	}
+}
+
+func TestECSAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler))(middleware.ECSAccessLog(logger)(http.HandlerFunc(hello)))
+
+	req := httptest.NewRequest(http.MethodGet, "/items?page=2", nil)
+	req.RemoteAddr = "[2001:db8::1]:443"
+	req.Header.Set("User-Agent", "test-agent")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var rec struct {
+		Msg  string `json:"msg"`
+		HTTP struct {
+			Version  string                  `json:"version"`
+			Request  struct{ Method string } `json:"request"`
+			Response struct {
+				StatusCode int                   `json:"status_code"`
+				Body       struct{ Bytes int64 } `json:"body"`
+			} `json:"response"`
+		} `json:"http"`
+		URL struct {
+			Path  string `json:"path"`
+			Query string `json:"query"`
+		} `json:"url"`
+		Client    struct{ Address string }  `json:"client"`
+		UserAgent struct{ Original string } `json:"user_agent"`
+		Event     struct{ Duration int64 }  `json:"event"`
+		Trace     struct{ ID string }       `json:"trace"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if rec.Msg != "GET /items?page=2 HTTP/1.1" || rec.HTTP.Version != "1.1" || rec.HTTP.Request.Method != "GET" ||
+		rec.HTTP.Response.StatusCode != 201 || rec.HTTP.Response.Body.Bytes != 5 ||
+		rec.URL.Path != "/items" || rec.URL.Query != "page=2" || rec.Client.Address != "2001:db8::1" ||
+		rec.UserAgent.Original != "test-agent" || rec.Event.Duration <= 0 {
+		t.Fatalf("unexpected record: %s", out.String())
+	}
+	if rec.Trace.ID == "" || rec.Trace.ID != rr.Header().Get(trace.TxIdHeader) {
+		t.Fatalf("trace.id = %q, response X-Tx-Id = %q", rec.Trace.ID, rr.Header().Get(trace.TxIdHeader))
+	}
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// maxFieldLog bounds the value of a logged multipart form field.
+const maxFieldLog = 256
+
+// bodyLog renders captured bodies for logging according to their
+// Content-Type and Content-Encoding.
+type bodyLog struct {
+	policy  *redact.Policy
+	limit   int64 // bytes of decoded body kept
+	preview int   // bytes of binary bodies logged as hex, none when 0
+}
+
+// attrs returns the log arguments describing a captured, possibly truncated,
+// body prefix. Compressed bodies are decoded as far as the captured bytes
+// allow, forms are logged field by field, multipart bodies as a summary of
+// their parts and binary bodies as a size and an optional hex preview.
+func (b bodyLog) attrs(h http.Header, data []byte) []any {
+	contentType := h.Get("Content-Type")
+	if encoding := h.Get("Content-Encoding"); encoding != "" && len(data) > 0 {
+		plain, ok := decodeBody(encoding, data, b.limit)
+		if !ok {
+			return b.binary(contentType, data, "encoding", encoding)
+		}
+		data = plain
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case len(data) == 0:
+		return []any{"body", ""}
+	case mediaType == "application/x-www-form-urlencoded":
+		return b.form(data)
+	case mediaType == "multipart/form-data" && params["boundary"] != "":
+		return b.multipart(data, params["boundary"])
+	case !isTextual(mediaType, data):
+		return b.binary(contentType, data)
+	}
+	return []any{"body", b.text(contentType, data)}
+}
+
+// text masks sensitive data of a textual body and truncates it.
+func (b bodyLog) text(contentType string, data []byte) string {
+	return utils.TruncateString(string(b.policy.Body(contentType, data)), b.limit)
+}
+
+// form logs the fields of an urlencoded form as a group.
+func (b bodyLog) form(data []byte) []any {
+	masked := b.policy.Body("application/x-www-form-urlencoded", data)
+	values, err := url.ParseQuery(string(masked))
+	if err != nil {
+		return []any{"body", utils.TruncateString(string(masked), b.limit)}
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	fields := make([]any, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, slog.String(k, strings.Join(values[k], ",")))
+	}
+	return []any{slog.Group("form", fields...)}
+}
+
+// multipart summarises the parts of a multipart/form-data body. File contents
+// are never logged; values of plain fields are, after redaction.
+func (b bodyLog) multipart(data []byte, boundary string) []any {
+	var parts []map[string]any
+	truncated := false
+	reader := multipart.NewReader(bytes.NewReader(data), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			truncated = true
+			break
+		}
+		summary := map[string]any{"name": part.FormName()}
+		var value bytes.Buffer
+		var size int64
+		if filename := part.FileName(); filename != "" {
+			summary["filename"] = filename
+			size, err = io.Copy(io.Discard, part)
+		} else {
+			size, err = io.Copy(&utils.CappedWriter{Writer: &value, Remain: maxFieldLog}, part)
+		}
+		summary["size"] = size
+		if ct := part.Header.Get("Content-Type"); ct != "" {
+			summary["contentType"] = ct
+		}
+		if _, isFile := summary["filename"]; !isFile {
+			summary["value"] = b.field(part.FormName(), value.Bytes())
+		}
+		parts = append(parts, summary)
+		if err != nil {
+			truncated = true
+			break
+		}
+	}
+	args := []any{slog.Any("parts", parts)}
+	if truncated {
+		args = append(args, "partsTruncated", true)
+	}
+	return args
+}
+
+// field redacts a form field value the same way a urlencoded field is.
+func (b bodyLog) field(name string, value []byte) string {
+	encoded := url.Values{name: {string(value)}}.Encode()
+	masked, err := url.ParseQuery(string(b.policy.Body("application/x-www-form-urlencoded", []byte(encoded))))
+	if err != nil {
+		return ""
+	}
+	return string(b.policy.Body("text/plain", []byte(masked.Get(name))))
+}
+
+// binary describes a body that cannot be logged as text.
+func (b bodyLog) binary(contentType string, data []byte, args ...any) []any {
+	args = append(args, "binary", true)
+	if contentType != "" {
+		args = append(args, "contentType", contentType)
+	}
+	if b.preview > 0 {
+		args = append(args, "bodyHex", hex.EncodeToString(data[:min(len(data), b.preview)]))
+	}
+	return args
+}
+
+// isTextual reports whether a body of the given media type can be logged as
+// text. Bodies without a media type are sniffed.
+func isTextual(mediaType string, data []byte) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	case mediaType == "":
+		return utf8.Valid(trimPartialRune(data)) && bytes.IndexByte(data, 0) < 0
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ndjson", "application/graphql", "application/yaml",
+		"application/x-yaml", "application/sql":
+		return true
+	}
+	return false
+}
+
+// trimPartialRune drops a multi-byte rune cut by truncation at the end of data.
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// decodeBody undoes the Content-Encoding of a captured body prefix, keeping
+// at most limit decoded bytes. Whatever decodes before the captured bytes run
+// out is returned. It reports false for unknown or corrupt encodings.
+func decodeBody(contentEncoding string, data []byte, limit int64) ([]byte, bool) {
+	encodings := strings.Split(contentEncoding, ",")
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		src := bytes.NewReader(data)
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(src)
+			if err != nil {
+				return nil, false
+			}
+			defer gz.Close()
+			reader = gz
+		case "deflate":
+			// "deflate" is zlib wrapped, but some servers send raw deflate
+			if zr, err := zlib.NewReader(src); err == nil {
+				defer zr.Close()
+				reader = zr
+			} else {
+				fr := flate.NewReader(bytes.NewReader(data))
+				defer fr.Close()
+				reader = fr
+			}
+		case "br":
+			reader = brotli.NewReader(src)
+		case "zstd":
+			zr, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, false
+			}
+			defer zr.Close()
+			reader = zr
+		default:
+			return nil, false
+		}
+		plain, err := io.ReadAll(io.LimitReader(reader, limit+1)) // keep whatever decoded before the cut
+		if len(plain) == 0 && err != nil {
+			return nil, false
+		}
+		data = plain
+	}
+	return data, true
+}
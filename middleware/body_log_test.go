@@ -0,0 +1,143 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/redact"
+)
+
+func serveLogged(t *testing.T, req *http.Request, next http.HandlerFunc, opts ...middleware.TraceOption) string {
+	t.Helper()
+	var logBuf bytes.Buffer
+	middleware.TraceMiddleware(debugLogger(&logBuf), opts...)(next).ServeHTTP(httptest.NewRecorder(), req)
+	return logBuf.String()
+}
+
+func drain(w http.ResponseWriter, r *http.Request) { io.Copy(io.Discard, r.Body) }
+
+func TestBodyLog_Form(t *testing.T) {
+	t.Cleanup(func() { redact.SetDefault(redact.DefaultPolicy()) })
+	redact.SetDefault(&redact.Policy{BodyFields: []string{"password"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("user=ana&password=hunter2&tag=a&tag=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logs := serveLogged(t, req, drain)
+
+	for _, want := range []string{"form.user=ana", "form.password=[REDACTED]", "form.tag=a,b"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "hunter2") {
+		t.Errorf("password logged:\n%s", logs)
+	}
+}
+
+func TestBodyLog_MultipartSummary(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "holiday")
+	fw, _ := mw.CreateFormFile("photo", "beach.jpg")
+	fw.Write([]byte("JPEGCONTENTS"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	logs := serveLogged(t, req, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("handler could not parse upload: %v", err)
+		}
+	})
+
+	for _, want := range []string{"name:photo", "filename:beach.jpg", "size:12", "value:holiday"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "JPEGCONTENTS") {
+		t.Errorf("file contents logged:\n%s", logs)
+	}
+}
+
+func TestBodyLog_Binary(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nrest-of-image")
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}
+
+	logs := serveLogged(t, httptest.NewRequest(http.MethodGet, "/logo.png", nil), next)
+	if !strings.Contains(logs, "binary=true") || strings.Contains(logs, "rest-of-image") {
+		t.Errorf("binary body not skipped:\n%s", logs)
+	}
+
+	logs = serveLogged(t, httptest.NewRequest(http.MethodGet, "/logo.png", nil), next, middleware.WithBinaryPreview(4))
+	if !strings.Contains(logs, "bodyHex=89504e47\n") {
+		t.Errorf("missing hex preview:\n%s", logs)
+	}
+}
+
+func TestBodyLog_Decompression(t *testing.T) {
+	const plain = `{"greeting":"hello compressed world"}`
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+	}
+	for name, enc := range encoders {
+		t.Run(name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			zw := enc(&compressed)
+			zw.Write([]byte(plain))
+			zw.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", name)
+			logs := serveLogged(t, req, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", name)
+				w.Write(compressed.Bytes())
+			})
+
+			if n := strings.Count(logs, "hello compressed world"); n != 2 {
+				t.Errorf("decoded body logged %d times, want request and response:\n%s", n, logs)
+			}
+		})
+	}
+}
+
+func TestBodyLog_CorruptEncoding(t *testing.T) {
+	logs := serveLogged(t, httptest.NewRequest(http.MethodGet, "/", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("not gzip at all"))
+	})
+	if !strings.Contains(logs, "encoding=gzip binary=true") {
+		t.Errorf("corrupt body not flagged:\n%s", logs)
+	}
+}
+
+func TestBodyLog_SniffsUntypedBodies(t *testing.T) {
+	logs := serveLogged(t, httptest.NewRequest(http.MethodGet, "/", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x00, 0x01, 0xff, 0xfe})
+	})
+	if !strings.Contains(logs, "binary=true") {
+		t.Errorf("untyped binary body not detected:\n%s", logs)
+	}
+}
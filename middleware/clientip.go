@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers understood by ClientIP.
+const (
+	ForwardedHeader     = "Forwarded"
+	XForwardedForHeader = "X-Forwarded-For"
+	XRealIPHeader       = "X-Real-IP"
+)
+
+type clientIPKey struct{}
+
+// WithClientIP returns ctx carrying the client address of the request.
+func WithClientIP(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, addr)
+}
+
+// ClientIPFrom returns the client address stored by ClientIP.
+func ClientIPFrom(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientIPKey{}).(netip.Addr)
+	return addr, ok && addr.IsValid()
+}
+
+// ClientAddress returns the client address of r: the one resolved by ClientIP
+// when it ran, the host of r.RemoteAddr otherwise.
+func ClientAddress(r *http.Request) string {
+	if addr, ok := ClientIPFrom(r.Context()); ok {
+		return addr.String()
+	}
+	return remoteHost(r)
+}
+
+// ParseCIDRs parses trusted proxy ranges. Bare addresses are accepted as
+// single host ranges.
+func ParseCIDRs(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			addr, err := netip.ParseAddr(c)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", c, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", c, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// ClientIPResolver computes the client address of requests that went
+// through reverse proxies. Forwarding headers are only believed when the
+// peer, and every hop after the client, is a trusted proxy.
+type ClientIPResolver struct {
+	// TrustedProxies lists the ranges of the proxies in front of the
+	// server. When empty, forwarding headers are ignored.
+	TrustedProxies []netip.Prefix
+	// Headers lists the forwarding headers to read, the first present one
+	// wins. Forwarded, X-Forwarded-For and X-Real-IP when empty.
+	Headers []string
+}
+
+// NewClientIPResolver returns a resolver trusting the given CIDRs.
+func NewClientIPResolver(trustedCIDRs ...string) (*ClientIPResolver, error) {
+	prefixes, err := ParseCIDRs(trustedCIDRs...)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{TrustedProxies: prefixes}, nil
+}
+
+func (c *ClientIPResolver) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range c.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client address of r.
+func (c *ClientIPResolver) Resolve(r *http.Request) (netip.Addr, bool) {
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !c.trusted(peer) {
+		return peer, true
+	}
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = []string{ForwardedHeader, XForwardedForHeader, XRealIPHeader}
+	}
+	for _, name := range headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		var hops []string
+		switch http.CanonicalHeaderKey(name) {
+		case ForwardedHeader:
+			hops = forwardedFor(values)
+		case http.CanonicalHeaderKey(XRealIPHeader):
+			hops = values[len(values)-1:]
+		default:
+			for _, v := range values {
+				hops = append(hops, strings.Split(v, ",")...)
+			}
+		}
+		return c.walk(peer, hops), true
+	}
+	return peer, true
+}
+
+// walk goes through hops from the nearest to the farthest and returns the
+// first address not belonging to a trusted proxy. An unparsable hop stops
+// the walk: nothing before it can be believed.
+func (c *ClientIPResolver) walk(peer netip.Addr, hops []string) netip.Addr {
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHostAddr(strings.TrimSpace(hops[i]))
+		if !ok {
+			break
+		}
+		client = addr
+		if !c.trusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// parseHostAddr parses an address with an optional port, IPv6 addresses
+// possibly in brackets.
+func parseHostAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i] // zone
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded header
+// values, in order. Obfuscated identifiers and "unknown" are kept so they
+// stop the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ClientIP returns a middleware storing the client address computed by
+// resolver in the request context, where ClientIPFrom, ClientAddress, the
+// access logs, rate limiting and TraceMiddleware find it. Install it in
+// front of them.
+func ClientIP(resolver *ClientIPResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if addr, ok := resolver.Resolve(r); ok {
+				r = r.WithContext(WithClientIP(r.Context(), addr))
+			}
+			next.ServeHTTP(w, r)
+			recordPattern(r)
+		})
+	}
+}
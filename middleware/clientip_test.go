@@ -0,0 +1,96 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := middleware.NewClientIPResolver("10.0.0.0/8", "192.0.2.10", "2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.5:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"no header", "10.1.1.1:4000", nil, "10.1.1.1"},
+		{"xff single hop", "10.1.1.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"xff skips trusted hops", "10.1.1.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.7, 192.0.2.10, 10.2.2.2"}, "198.51.100.7"},
+		{"xff spoofed prefix", "10.1.1.1:4000", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7"}, "198.51.100.7"},
+		{"xff garbage stops walk", "10.1.1.1:4000", map[string]string{"X-Forwarded-For": "198.51.100.7, bogus, 10.2.2.2"}, "10.2.2.2"},
+		{"xff all trusted", "10.1.1.1:4000", map[string]string{"X-Forwarded-For": "10.3.3.3, 10.2.2.2"}, "10.3.3.3"},
+		{"real ip", "10.1.1.1:4000", map[string]string{"X-Real-IP": "198.51.100.8"}, "198.51.100.8"},
+		{"forwarded", "10.1.1.1:4000", map[string]string{"Forwarded": `for=198.51.100.9;proto=https, for="[2001:db8::7]:4711";by=10.1.1.1`}, "2001:db8::7"},
+		{"forwarded over xff", "10.1.1.1:4000", map[string]string{"Forwarded": "for=198.51.100.9", "X-Forwarded-For": "1.1.1.1"}, "198.51.100.9"},
+		{"forwarded unknown", "10.1.1.1:4000", map[string]string{"Forwarded": "for=unknown"}, "10.1.1.1"},
+		{"ipv6 peer", "[2001:db8:ffff::1]:443", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"mapped ipv4 peer", "[::ffff:10.1.1.1]:443", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = c.remote
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			got, ok := resolver.Resolve(req)
+			if !ok || got.String() != c.want {
+				t.Fatalf("got %v (%v), want %s", got, ok, c.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs_Invalid(t *testing.T) {
+	if _, err := middleware.ParseCIDRs("10.0.0.0/33"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := middleware.ParseCIDRs("proxy.local"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestClientIP_UsedByLogsAndRateLimit(t *testing.T) {
+	resolver, _ := middleware.NewClientIPResolver("10.0.0.0/8")
+	var logBuf, accessBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	limiter := ratelimit.NewSlidingWindow(1, time.Minute, nil)
+
+	h := middleware.ClientIP(resolver)(
+		middleware.AccessLog(&accessBuf, middleware.MustParseAccessLogFormat("%h"))(
+			middleware.TraceMiddleware(logger)(
+				middleware.RateLimit(logger, limiter)(http.HandlerFunc(ok)))))
+
+	send := func(client string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:5000" // the load balancer
+		req.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if send("198.51.100.1") != http.StatusOK || send("198.51.100.2") != http.StatusOK {
+		t.Fatal("clients behind the same proxy share a limit")
+	}
+	if send("198.51.100.1") != http.StatusTooManyRequests {
+		t.Fatal("client not limited")
+	}
+	if got := strings.Fields(accessBuf.String()); len(got) != 3 || got[0] != "198.51.100.1" || got[1] != "198.51.100.2" {
+		t.Fatalf("access log: %q", accessBuf.String())
+	}
+	if !strings.Contains(logBuf.String(), "client=198.51.100.1") {
+		t.Fatalf("trace logs miss the client:\n%s", logBuf.String())
+	}
+}
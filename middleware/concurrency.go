@@ -0,0 +1,360 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
+)
+
+// Priority is the class of a request for load shedding. When slots free up,
+// queued requests of a higher priority go first, and a full queue makes room
+// for a request by shedding one of lower priority.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+	numPriorities
+)
+
+// ParsePriority parses "low", "normal", "high" or "critical", or their
+// numeric values.
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low", "0":
+		return PriorityLow, true
+	case "normal", "1":
+		return PriorityNormal, true
+	case "high", "2":
+		return PriorityHigh, true
+	case "critical", "3":
+		return PriorityCritical, true
+	}
+	return PriorityNormal, false
+}
+
+// PriorityFunc classifies a request.
+type PriorityFunc func(r *http.Request) Priority
+
+// PriorityFromHeader reads the priority of a request from a header, normal
+// when absent or invalid. Only use it for headers set by trusted parties.
+func PriorityFromHeader(name string) PriorityFunc {
+	return func(r *http.Request) Priority {
+		p, _ := ParsePriority(r.Header.Get(name))
+		return p
+	}
+}
+
+var (
+	errQueueFull    = errors.New("queue full")
+	errQueueTimeout = errors.New("queue timeout")
+	errEvicted      = errors.New("evicted by a higher priority request")
+)
+
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+	admitted bool // set under the semaphore lock before ready is closed
+}
+
+// semaphore caps in-flight requests and queues the excess by priority.
+type semaphore struct {
+	mu       sync.Mutex
+	max      int
+	maxQueue int
+	inFlight int
+	queued   int
+	waiters  [numPriorities][]*waiter
+}
+
+func newSemaphore(max, maxQueue int) *semaphore {
+	return &semaphore{max: max, maxQueue: maxQueue}
+}
+
+func (s *semaphore) acquire(ctx context.Context, p Priority, timeout time.Duration) error {
+	s.mu.Lock()
+	if s.inFlight < s.max && s.queued == 0 {
+		s.inFlight++
+		s.mu.Unlock()
+		return nil
+	}
+	if s.queued >= s.maxQueue && !s.evictBelow(p) {
+		s.mu.Unlock()
+		return errQueueFull
+	}
+	w := &waiter{priority: p, ready: make(chan struct{})}
+	s.waiters[p] = append(s.waiters[p], w)
+	s.queued++
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		if w.admitted {
+			return nil
+		}
+		return errEvicted
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.admitted {
+		return nil // the slot was handed over while giving up
+	}
+	select {
+	case <-w.ready:
+		return errEvicted
+	default:
+	}
+	s.remove(w)
+	return err
+}
+
+// evictBelow sheds the newest queued request of a priority lower than p.
+func (s *semaphore) evictBelow(p Priority) bool {
+	for lower := PriorityLow; lower < p; lower++ {
+		if n := len(s.waiters[lower]); n > 0 {
+			w := s.waiters[lower][n-1]
+			s.waiters[lower] = s.waiters[lower][:n-1]
+			s.queued--
+			close(w.ready)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *semaphore) remove(w *waiter) {
+	q := s.waiters[w.priority]
+	for i, x := range q {
+		if x == w {
+			s.waiters[w.priority] = append(q[:i], q[i+1:]...)
+			s.queued--
+			return
+		}
+	}
+}
+
+// release frees a slot, handing it to the oldest waiter of the highest
+// priority.
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := numPriorities - 1; p >= PriorityLow; p-- {
+		if len(s.waiters[p]) > 0 {
+			w := s.waiters[p][0]
+			s.waiters[p] = s.waiters[p][1:]
+			s.queued--
+			w.admitted = true
+			close(w.ready)
+			return
+		}
+	}
+	s.inFlight--
+}
+
+func (s *semaphore) counts() (inFlight, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight, s.queued
+}
+
+type routeLimit struct {
+	path string
+	sem  *semaphore
+}
+
+// ConcurrencyOption configures a ConcurrencyLimiter.
+type ConcurrencyOption func(*ConcurrencyLimiter)
+
+// WithQueue lets up to size requests wait at most timeout for a slot before
+// they are shed. Without a queue, excess requests are shed at once; a size or
+// timeout that is not positive means no queue.
+func WithQueue(size int, timeout time.Duration) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) {
+		if size <= 0 || timeout <= 0 {
+			size, timeout = 0, 0
+		}
+		c.queueSize, c.queueTimeout = size, timeout
+	}
+}
+
+// WithRouteLimit caps the in-flight requests of a path, on top of the global
+// cap. A trailing "*" matches any suffix; the longest match wins.
+// NewConcurrencyLimiter panics when max is not positive.
+func WithRouteLimit(path string, max int) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) { c.routeMax[path] = max }
+}
+
+// WithPriorityFunc sets how requests are classified. Every request is
+// PriorityNormal by default. Priorities outside PriorityLow to
+// PriorityCritical are clamped to the nearest one.
+func WithPriorityFunc(f PriorityFunc) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) { c.priority = f }
+}
+
+// WithShedRetryAfter sets the Retry-After sent with shed requests, one
+// second by default.
+func WithShedRetryAfter(d time.Duration) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) { c.retryAfter = d }
+}
+
+// ConcurrencyLimiter caps the number of requests served at once, globally
+// and per route, and sheds the excess with 503 rather than letting latency
+// grow without bound.
+type ConcurrencyLimiter struct {
+	logger       *slog.Logger
+	global       *semaphore
+	routes       []routeLimit
+	routeMax     map[string]int
+	queueSize    int
+	queueTimeout time.Duration
+	priority     PriorityFunc
+	retryAfter   time.Duration
+	shed         atomic.Int64
+}
+
+// NewConcurrencyLimiter returns a limiter serving at most maxInFlight
+// requests at once. It panics when maxInFlight or a route limit is not
+// positive, as every request would be shed.
+func NewConcurrencyLimiter(l *slog.Logger, maxInFlight int, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	if maxInFlight <= 0 {
+		panic(fmt.Sprintf("middleware: invalid concurrency limit %d", maxInFlight))
+	}
+	c := &ConcurrencyLimiter{
+		logger:     l,
+		routeMax:   make(map[string]int),
+		priority:   func(*http.Request) Priority { return PriorityNormal },
+		retryAfter: time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.global = newSemaphore(maxInFlight, c.queueSize)
+	for path, max := range c.routeMax {
+		if max <= 0 {
+			panic(fmt.Sprintf("middleware: invalid concurrency limit %d for route %q", max, path))
+		}
+		c.routes = append(c.routes, routeLimit{path: path, sem: newSemaphore(max, c.queueSize)})
+	}
+	return c
+}
+
+// route returns the semaphore of the longest route matching path.
+func (c *ConcurrencyLimiter) route(path string) (string, *semaphore) {
+	paths := make([]string, len(c.routes))
+	for i, rl := range c.routes {
+		paths[i] = rl.path
+	}
+	i := longestMatch(paths, path)
+	if i < 0 {
+		return "", nil
+	}
+	return c.routes[i].path, c.routes[i].sem
+}
+
+// longestMatch returns the index of the pattern matching path best, or -1.
+// A pattern matches its exact path, or any path it prefixes when it ends in
+// "*". An exact match beats a prefix of the same length.
+func longestMatch(patterns []string, path string) int {
+	best, bestLen := -1, -1
+	for i, p := range patterns {
+		n := len(p) + 1
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			n = len(prefix)
+		} else if path != p {
+			continue
+		}
+		if n > bestLen {
+			best, bestLen = i, n
+		}
+	}
+	return best
+}
+
+// InFlight returns the number of requests being served.
+func (c *ConcurrencyLimiter) InFlight() int {
+	n, _ := c.global.counts()
+	return n
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (c *ConcurrencyLimiter) Queued() int {
+	_, q := c.global.counts()
+	for _, rl := range c.routes {
+		_, rq := rl.sem.counts()
+		q += rq
+	}
+	return q
+}
+
+// RouteCounts returns the in-flight and queued requests of a route limit.
+func (c *ConcurrencyLimiter) RouteCounts(path string) (inFlight, queued int) {
+	for _, rl := range c.routes {
+		if rl.path == path {
+			return rl.sem.counts()
+		}
+	}
+	return 0, 0
+}
+
+// Shed returns the number of requests shed so far.
+func (c *ConcurrencyLimiter) Shed() int64 {
+	return c.shed.Load()
+}
+
+// Middleware returns the middleware enforcing the limits.
+func (c *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		prio := min(max(c.priority(r), PriorityLow), PriorityCritical)
+		route, routeSem := c.route(r.URL.Path)
+		if routeSem != nil {
+			if err := routeSem.acquire(ctx, prio, c.queueTimeout); err != nil {
+				c.reject(w, r, err, route, prio)
+				return
+			}
+			defer routeSem.release()
+		}
+		if err := c.global.acquire(ctx, prio, c.queueTimeout); err != nil {
+			c.reject(w, r, err, "", prio)
+			return
+		}
+		defer c.global.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *ConcurrencyLimiter) reject(w http.ResponseWriter, r *http.Request, err error, route string, prio Priority) {
+	ctx := r.Context()
+	if ctx.Err() != nil {
+		return // the client left while queued
+	}
+	c.shed.Add(1)
+	args := []any{"Url", r.URL.Path, "method", r.Method, "reason", err.Error(), "priority", int(prio)}
+	if route != "" {
+		args = append(args, "route", route)
+	}
+	log.Log(c.logger, ctx, slog.LevelWarn, "Request shed", args...)
+	w.Header().Set("Retry-After", ceilSeconds(c.retryAfter))
+	problem.Error(w, r, http.StatusServiceUnavailable, "server overloaded")
+}
@@ -0,0 +1,264 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+)
+
+// blocking returns a handler that waits for release and reports on started
+// when a request begins.
+func blocking() (h http.Handler, started chan string, release chan struct{}) {
+	started = make(chan string, 16)
+	release = make(chan struct{})
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- r.URL.Path
+		<-release
+	})
+	return h, started, release
+}
+
+func serveAsync(h http.Handler, req *http.Request) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		done <- rr
+	}()
+	return done
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrencyLimiter_ShedsWithoutQueue(t *testing.T) {
+	var logBuf bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&syncWriter{w: &logBuf, mu: &mu}, nil))
+	next, started, release := blocking()
+	limiter := middleware.NewConcurrencyLimiter(logger, 1, middleware.WithShedRetryAfter(3*time.Second))
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler))(limiter.Middleware(next))
+
+	first := serveAsync(h, httptest.NewRequest(http.MethodGet, "/a", nil))
+	<-started
+	if limiter.InFlight() != 1 {
+		t.Fatalf("InFlight = %d", limiter.InFlight())
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/b", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "3" {
+		t.Fatalf("second request: %d Retry-After=%q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	close(release)
+	if rr := <-first; rr.Code != http.StatusOK {
+		t.Fatalf("first request: %d", rr.Code)
+	}
+	if limiter.InFlight() != 0 || limiter.Shed() != 1 {
+		t.Fatalf("InFlight = %d, Shed = %d", limiter.InFlight(), limiter.Shed())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(logBuf.String(), `msg="Request shed" trace=`+rr.Header().Get("X-Tx-Id")) {
+		t.Fatalf("shed not logged with trace:\n%s", logBuf.String())
+	}
+}
+
+func TestNewConcurrencyLimiter_InvalidLimits(t *testing.T) {
+	for name, build := range map[string]func(){
+		"zero":     func() { middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 0) },
+		"negative": func() { middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), -1) },
+		"zero route": func() {
+			middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1, middleware.WithRouteLimit("/a", 0))
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: NewConcurrencyLimiter did not panic", name)
+				}
+			}()
+			build()
+		}()
+	}
+}
+
+func TestConcurrencyLimiter_NonPositiveQueue(t *testing.T) {
+	for _, opt := range []middleware.ConcurrencyOption{middleware.WithQueue(-1, time.Minute), middleware.WithQueue(5, 0)} {
+		next, started, release := blocking()
+		limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1, opt)
+		h := limiter.Middleware(next)
+
+		first := serveAsync(h, httptest.NewRequest(http.MethodGet, "/a", nil))
+		<-started
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/b", nil))
+		if rr.Code != http.StatusServiceUnavailable || limiter.Queued() != 0 {
+			t.Errorf("second request: %d, Queued = %d", rr.Code, limiter.Queued())
+		}
+		close(release)
+		<-first
+	}
+}
+
+func TestConcurrencyLimiter_Queue(t *testing.T) {
+	next, started, release := blocking()
+	limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1, middleware.WithQueue(1, time.Minute))
+	h := limiter.Middleware(next)
+
+	first := serveAsync(h, httptest.NewRequest(http.MethodGet, "/1", nil))
+	<-started
+	second := serveAsync(h, httptest.NewRequest(http.MethodGet, "/2", nil))
+	waitFor(t, func() bool { return limiter.Queued() == 1 })
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/3", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request over queue: %d", rr.Code)
+	}
+
+	release <- struct{}{}
+	<-first
+	if path := <-started; path != "/2" {
+		t.Fatalf("started %s", path)
+	}
+	close(release)
+	if rr := <-second; rr.Code != http.StatusOK {
+		t.Fatalf("queued request: %d", rr.Code)
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	next, started, release := blocking()
+	defer close(release)
+	limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1, middleware.WithQueue(5, 20*time.Millisecond))
+	h := limiter.Middleware(next)
+
+	serveAsync(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusServiceUnavailable || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("status %d after %v", rr.Code, time.Since(start))
+	}
+	if limiter.Queued() != 0 {
+		t.Fatalf("Queued = %d", limiter.Queued())
+	}
+}
+
+func TestConcurrencyLimiter_Priorities(t *testing.T) {
+	next, started, release := blocking()
+	limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1,
+		middleware.WithQueue(1, time.Minute), middleware.WithPriorityFunc(middleware.PriorityFromHeader("X-Priority")))
+	h := limiter.Middleware(next)
+
+	withPriority := func(path, p string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Priority", p)
+		return req
+	}
+
+	first := serveAsync(h, withPriority("/busy", "normal"))
+	<-started
+	low := serveAsync(h, withPriority("/low", "low"))
+	waitFor(t, func() bool { return limiter.Queued() == 1 })
+	high := serveAsync(h, withPriority("/high", "high"))
+
+	if rr := <-low; rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("low priority request not shed: %d", rr.Code)
+	}
+	waitFor(t, func() bool { return limiter.Queued() == 1 })
+	release <- struct{}{}
+	<-first
+	if path := <-started; path != "/high" {
+		t.Fatalf("started %s", path)
+	}
+	close(release)
+	if rr := <-high; rr.Code != http.StatusOK {
+		t.Fatalf("high priority request: %d", rr.Code)
+	}
+}
+
+func TestConcurrencyLimiter_PriorityOutOfRange(t *testing.T) {
+	next, started, release := blocking()
+	limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 1,
+		middleware.WithQueue(2, time.Minute), middleware.WithPriorityFunc(func(r *http.Request) middleware.Priority {
+			if r.URL.Path == "/above" {
+				return middleware.PriorityCritical + 10
+			}
+			return -5
+		}))
+	h := limiter.Middleware(next)
+
+	first := serveAsync(h, httptest.NewRequest(http.MethodGet, "/below", nil))
+	<-started
+	below := serveAsync(h, httptest.NewRequest(http.MethodGet, "/below", nil))
+	waitFor(t, func() bool { return limiter.Queued() == 1 })
+	above := serveAsync(h, httptest.NewRequest(http.MethodGet, "/above", nil))
+	waitFor(t, func() bool { return limiter.Queued() == 2 })
+
+	// clamped to critical, the request above the range goes first
+	release <- struct{}{}
+	<-first
+	if path := <-started; path != "/above" {
+		t.Fatalf("started %s", path)
+	}
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{above, below} {
+		if rr := <-done; rr.Code != http.StatusOK {
+			t.Fatalf("request with an out of range priority: %d", rr.Code)
+		}
+	}
+}
+
+func TestConcurrencyLimiter_RouteLimit(t *testing.T) {
+	next, started, release := blocking()
+	limiter := middleware.NewConcurrencyLimiter(slog.New(slog.DiscardHandler), 10, middleware.WithRouteLimit("/reports/*", 1))
+	h := limiter.Middleware(next)
+
+	first := serveAsync(h, httptest.NewRequest(http.MethodGet, "/reports/1", nil))
+	<-started
+	if in, _ := limiter.RouteCounts("/reports/*"); in != 1 {
+		t.Fatalf("route in flight = %d", in)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/reports/2", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("second report: %d", rr.Code)
+	}
+	other := serveAsync(h, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if path := <-started; path != "/items" {
+		t.Fatalf("started %s", path)
+	}
+	close(release)
+	<-first
+	<-other
+}
+
+type syncWriter struct {
+	w  *bytes.Buffer
+	mu *sync.Mutex
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
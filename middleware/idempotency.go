@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/idempotency"
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// Headers of idempotent requests.
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKey bounds the length of Idempotency-Key values.
+const maxIdempotencyKey = 255
+
+// IdempotencyOption configures Idempotency.
+type IdempotencyOption func(*idempotencyConfig)
+
+type idempotencyConfig struct {
+	ttl     time.Duration
+	lease   time.Duration
+	scope   KeyFunc
+	maxBody int64
+	methods []string
+}
+
+// WithIdempotencyTTL sets how long responses are replayed, 24 hours by
+// default.
+func WithIdempotencyTTL(d time.Duration) IdempotencyOption {
+	return func(c *idempotencyConfig) { c.ttl = d }
+}
+
+// WithIdempotencyLease bounds how long a request holds its key, 5 minutes by
+// default. A key whose request died without completing is free again once
+// the lease is over.
+func WithIdempotencyLease(d time.Duration) IdempotencyOption {
+	return func(c *idempotencyConfig) { c.lease = d }
+}
+
+// WithIdempotencyScope keeps the keys of each client apart, e.g. with
+// KeyByHeader("Authorization"), so clients cannot replay each other's
+// responses. By default keys are shared by every client.
+func WithIdempotencyScope(scope KeyFunc) IdempotencyOption {
+	return func(c *idempotencyConfig) { c.scope = scope }
+}
+
+// WithIdempotencyMaxBody bounds the request bodies fingerprinted, larger
+// ones get 413, and the responses stored, larger ones are not. 1 MiB by
+// default.
+func WithIdempotencyMaxBody(n int64) IdempotencyOption {
+	return func(c *idempotencyConfig) { c.maxBody = n }
+}
+
+// WithIdempotencyMethods sets the methods honoring Idempotency-Key, POST and
+// PATCH by default.
+func WithIdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(c *idempotencyConfig) { c.methods = methods }
+}
+
+// Idempotency returns a middleware honoring the Idempotency-Key header of
+// POST and PATCH requests. The first request with a key runs and its
+// response is stored; repeats with the same method, URL and body get the
+// stored response back with Idempotent-Replayed set, without reaching the
+// handler. A repeat arriving while the first request still runs gets 409,
+// and a key reused for a different request gets 422.
+//
+// Only the headers set by the handler are stored, not those of the
+// middlewares in front. Server errors, panics and responses larger than the
+// body limit are not stored, so the request can be retried. When the store
+// fails, requests are let through.
+func Idempotency(l *slog.Logger, store idempotency.Store, opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	cfg := &idempotencyConfig{
+		ttl:     24 * time.Hour,
+		lease:   5 * time.Minute,
+		maxBody: 1 << 20,
+		methods: []string{http.MethodPost, http.MethodPatch},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !slices.Contains(cfg.methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				problem.Error(w, r, http.StatusBadRequest, "Idempotency-Key is too long")
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, cfg.maxBody+1))
+			if err != nil {
+				problem.Error(w, r, http.StatusBadRequest, "reading request body failed")
+				return
+			}
+			if int64(len(body)) > cfg.maxBody {
+				problem.Error(w, r, http.StatusRequestEntityTooLarge, "request body too large for an idempotent request")
+				return
+			}
+
+			ctx, info := withRequestInfo(r.Context())
+			r = r.WithContext(ctx)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			storeKey := cfg.storeKey(r, key)
+			token, rec, err := store.Begin(ctx, storeKey, fingerprint(r, body), cfg.lease)
+			switch {
+			case errors.Is(err, idempotency.ErrInFlight):
+				log.Log(l, ctx, slog.LevelWarn, "Idempotent request in flight", "Url", r.URL.Path, "method", r.Method)
+				problem.Error(w, r, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+				return
+			case errors.Is(err, idempotency.ErrMismatch):
+				log.Log(l, ctx, slog.LevelWarn, "Idempotency key reused", "Url", r.URL.Path, "method", r.Method)
+				problem.Error(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+				return
+			case err != nil:
+				log.Log(l, ctx, slog.LevelError, "Idempotency store failed", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			case rec != nil:
+				log.Log(l, ctx, slog.LevelInfo, "Idempotent request replayed", "Url", r.URL.Path, "method", r.Method, "status", rec.Status)
+				replay(w, rec)
+				return
+			}
+
+			// from here on the key is ours, and released unless the
+			// response is stored, so a panic does not leave it held
+			stored := false
+			storeCtx := context.WithoutCancel(ctx)
+			defer func() {
+				if stored {
+					return
+				}
+				if err := store.Release(storeCtx, storeKey, token); err != nil {
+					idempotencyStoreFailed(l, ctx, r, err)
+				}
+			}()
+
+			before := w.Header().Clone()
+			resp := &utils.ResponseRecorder{ResponseWriter: w, Limit: cfg.maxBody}
+			next.ServeHTTP(resp, r)
+			recordPattern(r)
+
+			timedOut, _ := info.outcome(ctx)
+			status := resp.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError || timedOut || resp.Hijacked || resp.Truncated() {
+				return
+			}
+			err = store.Complete(storeCtx, storeKey, token, idempotency.Record{
+				Status: status,
+				Header: changedHeaders(before, w.Header()),
+				Body:   resp.Buf.Bytes(),
+			}, cfg.ttl)
+			if err != nil {
+				idempotencyStoreFailed(l, ctx, r, err)
+				// a claim taken over by another request is not ours to
+				// release
+				stored = errors.Is(err, idempotency.ErrNotHeld)
+				return
+			}
+			stored = true
+		})
+	}
+}
+
+// idempotencyStoreFailed logs err, at warn level when the lease of the
+// request ran out so the key went to a later request.
+func idempotencyStoreFailed(l *slog.Logger, ctx context.Context, r *http.Request, err error) {
+	if errors.Is(err, idempotency.ErrNotHeld) {
+		log.Log(l, ctx, slog.LevelWarn, "Idempotency key lease expired", "Url", r.URL.Path, "method", r.Method)
+		return
+	}
+	log.Log(l, ctx, slog.LevelError, "Idempotency store failed", "error", err)
+}
+
+// storeKey namespaces key by the scope of the request. Keys are hashed, so
+// client supplied values and scopes are not kept in the store as is.
+func (c *idempotencyConfig) storeKey(r *http.Request, key string) string {
+	scope := ""
+	if c.scope != nil {
+		scope, _ = c.scope(r)
+	}
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint identifies the request a key was used for.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\x00")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changedHeaders returns the headers of after that differ from before.
+func changedHeaders(before, after http.Header) http.Header {
+	changed := http.Header{}
+	for k, v := range after {
+		if !slices.Equal(before[k], v) {
+			changed[k] = slices.Clone(v)
+		}
+	}
+	return changed
+}
+
+// replay writes a stored response.
+func replay(w http.ResponseWriter, rec *idempotency.Record) {
+	h := w.Header()
+	for k, v := range rec.Header {
+		h[k] = v
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
@@ -0,0 +1,164 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/idempotency"
+	"github.com/Guadalsistema/net-utils/middleware"
+)
+
+func newIdempotencyStore(t *testing.T) *idempotency.SQLiteStore {
+	t.Helper()
+	s, err := idempotency.OpenSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func idempotentRequest(key, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+	return req
+}
+
+func TestIdempotency_Replay(t *testing.T) {
+	var calls atomic.Int32
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	h := middleware.Idempotency(logger, newIdempotencyStore(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append(body, byte('0'+n)))
+	}))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		rr.Header().Set("X-Outer", "set in front")
+		h.ServeHTTP(rr, idempotentRequest(key, "order"))
+		return rr
+	}
+
+	first := send("k1")
+	if first.Code != http.StatusCreated || first.Body.String() != "order1" || first.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Fatalf("first response: %d %q %v", first.Code, first.Body, first.Header())
+	}
+	again := send("k1")
+	if again.Code != http.StatusCreated || again.Body.String() != "order1" || again.Header().Get("Location") != "/orders/1" {
+		t.Fatalf("replayed response: %d %q %v", again.Code, again.Body, again.Header())
+	}
+	if again.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+		t.Fatalf("replay not marked: %v", again.Header())
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls.Load())
+	}
+	if !strings.Contains(logBuf.String(), `msg="Idempotent request replayed"`) {
+		t.Fatalf("replay not logged:\n%s", logBuf.String())
+	}
+
+	if rr := send("k2"); rr.Body.String() != "order2" {
+		t.Fatalf("other key replayed: %q", rr.Body)
+	}
+	if rr := send(""); rr.Body.String() != "order3" {
+		t.Fatalf("request without key: %q", rr.Body)
+	}
+}
+
+func TestIdempotency_Mismatch(t *testing.T) {
+	h := middleware.Idempotency(slog.Default(), newIdempotencyStore(t))(http.HandlerFunc(ok))
+
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", "a"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k", "b"))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused for another body: %d, want 422", rr.Code)
+	}
+}
+
+func TestIdempotency_InFlight(t *testing.T) {
+	store := newIdempotencyStore(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := middleware.Idempotency(slog.Default(), store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", "a"))
+	}()
+	<-started
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k", "a"))
+	close(release)
+	<-done
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("concurrent duplicate: %d, want 409", rr.Code)
+	}
+}
+
+func TestIdempotency_ServerErrorsNotStored(t *testing.T) {
+	var calls atomic.Int32
+	h := middleware.Idempotency(slog.Default(), newIdempotencyStore(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", "a"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k", "a"))
+	if rr.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Fatalf("retry after a server error: %d after %d calls", rr.Code, calls.Load())
+	}
+}
+
+func TestIdempotency_PanicReleasesKey(t *testing.T) {
+	var calls atomic.Int32
+	h := middleware.Recovery(slog.Default())(middleware.Idempotency(slog.Default(), newIdempotencyStore(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})))
+
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", "a"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k", "a"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic: %d, want 201", rr.Code)
+	}
+}
+
+func TestIdempotency_Limits(t *testing.T) {
+	h := middleware.Idempotency(slog.Default(), newIdempotencyStore(t), middleware.WithIdempotencyMaxBody(4))(http.HandlerFunc(ok))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k", "too long"))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body: %d, want 413", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest(strings.Repeat("k", 256), "a"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("long key: %d, want 400", rr.Code)
+	}
+}
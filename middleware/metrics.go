@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/metrics"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// UnmatchedRoute is the route label of requests no ServeMux pattern matched.
+const UnmatchedRoute = "unmatched"
+
+// MetricsOption configures Metrics.
+type MetricsOption func(*metricsConfig)
+
+type metricsConfig struct {
+	namespace       string
+	durationBuckets []float64
+	sizeBuckets     []float64
+}
+
+// WithMetricsNamespace prefixes the metric names with namespace and an
+// underscore.
+func WithMetricsNamespace(namespace string) MetricsOption {
+	return func(c *metricsConfig) { c.namespace = namespace + "_" }
+}
+
+// WithDurationBuckets sets the buckets of the latency histogram, in seconds.
+// metrics.DefBuckets by default.
+func WithDurationBuckets(buckets []float64) MetricsOption {
+	return func(c *metricsConfig) { c.durationBuckets = buckets }
+}
+
+// WithSizeBuckets sets the buckets of the request and response size
+// histograms, in bytes. Powers of 4 from 64B to 16MiB by default.
+func WithSizeBuckets(buckets []float64) MetricsOption {
+	return func(c *metricsConfig) { c.sizeBuckets = buckets }
+}
+
+// Metrics returns a middleware recording in reg, per method, route and status
+// class:
+//
+//   - http_server_requests_total, the number of requests;
+//   - http_server_request_duration_seconds, a latency histogram;
+//   - http_server_request_size_bytes and http_server_response_size_bytes,
+//     histograms of the body sizes;
+//
+// and http_server_requests_in_flight, per method, as the route is only known
+// once the handler ran. The route is the http.ServeMux pattern that matched,
+// without its method, or UnmatchedRoute; install Metrics in front of the mux.
+// Unknown methods are counted as "OTHER", keeping the label cardinality
+// bounded.
+func Metrics(reg *metrics.Registry, opts ...MetricsOption) func(http.Handler) http.Handler {
+	cfg := &metricsConfig{sizeBuckets: metrics.ExponentialBuckets(64, 4, 10)}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	ns := cfg.namespace
+	requests := reg.Counter(ns+"http_server_requests_total", "Number of HTTP requests served.", "method", "route", "status")
+	duration := reg.Histogram(ns+"http_server_request_duration_seconds", "Time taken to serve HTTP requests.", cfg.durationBuckets, "method", "route", "status")
+	requestSize := reg.Histogram(ns+"http_server_request_size_bytes", "Size of HTTP request bodies.", cfg.sizeBuckets, "method", "route")
+	responseSize := reg.Histogram(ns+"http_server_response_size_bytes", "Size of HTTP response bodies.", cfg.sizeBuckets, "method", "route", "status")
+	inFlight := reg.Gauge(ns+"http_server_requests_in_flight", "Number of HTTP requests being served.", "method")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			method := methodLabel(r.Method)
+			inFlight.Inc(method)
+			defer inFlight.Dec(method)
+
+			ctx, info := withRequestInfo(r.Context())
+			r = r.WithContext(ctx)
+			body := &countingBody{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			resp := &utils.ResponseRecorder{ResponseWriter: w, Status: http.StatusOK, Limit: -1}
+			next.ServeHTTP(resp, r)
+
+			recordPattern(r)
+			route := UnmatchedRoute
+			if pattern, _ := info.route(); pattern != "" {
+				route = routeLabel(pattern)
+			}
+			status := statusClass(resp.Status)
+			requests.Inc(method, route, status)
+			duration.Observe(time.Since(start).Seconds(), method, route, status)
+			requestSize.Observe(float64(body.n.Load()), method, route)
+			responseSize.Observe(float64(resp.Size), method, route, status)
+		})
+	}
+}
+
+// countingBody counts the bytes of the request body read by the handler.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusClass returns "2xx" for 200 and so on.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
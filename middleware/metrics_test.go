@@ -0,0 +1,59 @@
+package middleware_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/metrics"
+	"github.com/Guadalsistema/net-utils/middleware"
+)
+
+func TestMetrics_LabelsByPattern(t *testing.T) {
+	reg := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	// TraceMiddleware copies the request between Metrics and the mux
+	h := middleware.Metrics(reg)(middleware.TraceMiddleware(slog.New(slog.DiscardHandler))(mux))
+
+	for _, id := range []string{"1", "2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users/"+id, strings.NewReader("payload")))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/users/1", nil))
+
+	var b strings.Builder
+	reg.WriteText(&b)
+	out := b.String()
+	for _, want := range []string{
+		`http_server_requests_total{method="POST",route="/users/{id}",status="2xx"} 2`,
+		`http_server_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_server_requests_total{method="OTHER",route="unmatched",status="4xx"} 1`,
+		`http_server_request_duration_seconds_count{method="POST",route="/users/{id}",status="2xx"} 2`,
+		`http_server_request_size_bytes_sum{method="POST",route="/users/{id}"} 14`,
+		`http_server_response_size_bytes_sum{method="POST",route="/users/{id}",status="2xx"} 14`,
+		`http_server_requests_in_flight{method="POST"} 0`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetrics_Namespace(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := middleware.Metrics(reg, middleware.WithMetricsNamespace("api"), middleware.WithDurationBuckets([]float64{1}))(http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	var b strings.Builder
+	reg.WriteText(&b)
+	if !strings.Contains(b.String(), `api_http_server_request_duration_seconds_bucket{method="GET",route="unmatched",status="4xx",le="1"} 1`) {
+		t.Fatalf("got:\n%s", b.String())
+	}
+}
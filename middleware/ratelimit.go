@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+// KeyFunc identifies the client a request is accounted to. Requests for which
+// it returns false are not limited.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// KeyByIP accounts requests to the client IP address, as resolved by
+// ClientIP when it runs in front.
+func KeyByIP(r *http.Request) (string, bool) {
+	return "ip:" + ClientAddress(r), true
+}
+
+// KeyByHeader accounts requests to the value of a header, typically an API
+// key. Values are hashed so secrets are not kept in the store. Requests
+// without the header are not limited; chain another middleware for them.
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		v := r.Header.Get(name)
+		if v == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "key:" + hex.EncodeToString(sum[:16]), true
+	}
+}
+
+// RateLimitOption configures RateLimit.
+type RateLimitOption func(*rateLimitConfig)
+
+type rateLimitConfig struct {
+	key        KeyFunc
+	prefix     string
+	failClosed bool
+}
+
+// WithRateLimitKey sets how requests are accounted. The default is KeyByIP.
+func WithRateLimitKey(key KeyFunc) RateLimitOption {
+	return func(c *rateLimitConfig) { c.key = key }
+}
+
+// WithRateLimitPrefix namespaces the keys, so several limits can share a
+// store.
+func WithRateLimitPrefix(prefix string) RateLimitOption {
+	return func(c *rateLimitConfig) { c.prefix = prefix }
+}
+
+// WithFailClosed rejects requests with 503 when the store fails. By default
+// requests are let through.
+func WithFailClosed() RateLimitOption {
+	return func(c *rateLimitConfig) { c.failClosed = true }
+}
+
+// RateLimit returns a middleware enforcing limiter. Every response carries
+// the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers; rejected requests get a 429 problem with
+// Retry-After.
+func RateLimit(l *slog.Logger, limiter ratelimit.Limiter, opts ...RateLimitOption) func(http.Handler) http.Handler {
+	cfg := &rateLimitConfig{key: KeyByIP}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := cfg.key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			res, err := limiter.Allow(ctx, cfg.prefix+key)
+			if err != nil {
+				log.Log(l, ctx, slog.LevelError, "Rate limit check failed", "error", err)
+				if cfg.failClosed {
+					problem.Error(w, r, http.StatusServiceUnavailable, "")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+			h.Set("RateLimit-Policy", strconv.Itoa(res.Limit)+";w="+ceilSeconds(res.Period))
+			if res.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			log.Log(l, ctx, slog.LevelWarn, "Rate limit exceeded", "Url", r.URL.Path, "method", r.Method, "retryAfter", res.RetryAfter)
+			problem.Error(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+		})
+	}
+}
+
+// ceilSeconds renders d as whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/problem"
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+func ok(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+func TestRateLimit_HeadersAndRejection(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	limiter := ratelimit.NewSlidingWindow(2, time.Minute, nil)
+	h := middleware.TraceMiddleware(logger)(middleware.RateLimit(logger, limiter)(http.HandlerFunc(ok)))
+
+	send := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("192.0.2.1:1000")
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("first request: %d %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("RateLimit-Policy = %q", rr.Header().Get("RateLimit-Policy"))
+	}
+	send("192.0.2.1:1001")
+	rr = send("192.0.2.1:1002")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("third request: %d %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("Content-Type = %q", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(logBuf.String(), `level=WARN msg="Rate limit exceeded" trace=`) {
+		t.Fatalf("rejection not logged with trace:\n%s", logBuf.String())
+	}
+
+	if rr := send("192.0.2.2:1000"); rr.Code != http.StatusOK {
+		t.Fatalf("other IP limited: %d", rr.Code)
+	}
+}
+
+func TestRateLimit_KeyByHeader(t *testing.T) {
+	limiter := ratelimit.NewTokenBucket(1, time.Hour, 1, nil)
+	h := middleware.RateLimit(slog.New(slog.DiscardHandler), limiter,
+		middleware.WithRateLimitKey(middleware.KeyByHeader("X-Api-Key")))(http.HandlerFunc(ok))
+
+	send := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if send("alpha") != http.StatusOK || send("alpha") != http.StatusTooManyRequests {
+		t.Fatal("API key not limited")
+	}
+	if send("beta") != http.StatusOK {
+		t.Fatal("other API key limited")
+	}
+	if send("") != http.StatusOK || send("") != http.StatusOK {
+		t.Fatal("requests without key should not be limited")
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("database is locked")
+}
+
+func TestRateLimit_StoreFailure(t *testing.T) {
+	open := middleware.RateLimit(slog.New(slog.DiscardHandler), failingLimiter{})(http.HandlerFunc(ok))
+	rr := httptest.NewRecorder()
+	open.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("fail open: %d", rr.Code)
+	}
+
+	closed := middleware.RateLimit(slog.New(slog.DiscardHandler), failingLimiter{}, middleware.WithFailClosed())(http.HandlerFunc(ok))
+	rr = httptest.NewRecorder()
+	closed.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("fail closed: %d", rr.Code)
+	}
+}
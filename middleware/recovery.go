@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// defaultStackDepth is the number of frames logged for a panic.
+const defaultStackDepth = 16
+
+// PanicHook is called with every recovered panic, e.g. to report it to an
+// error tracker. The stack is already trimmed to the handler frames.
+type PanicHook func(r *http.Request, value any, stack string)
+
+// RecoveryOption configures Recovery.
+type RecoveryOption func(*recoveryConfig)
+
+type recoveryConfig struct {
+	hook       PanicHook
+	problem    bool
+	stackDepth int
+	redaction  *redact.Policy
+	txIdHeader string
+}
+
+// WithPanicHook registers a hook called after a panic is logged.
+func WithPanicHook(hook PanicHook) RecoveryOption {
+	return func(c *recoveryConfig) { c.hook = hook }
+}
+
+// WithProblemResponse answers with an RFC 9457 application/problem+json body
+// instead of a plain text 500.
+func WithProblemResponse() RecoveryOption {
+	return func(c *recoveryConfig) { c.problem = true }
+}
+
+// WithStackDepth sets how many stack frames are logged.
+func WithStackDepth(n int) RecoveryOption {
+	return func(c *recoveryConfig) {
+		if n > 0 {
+			c.stackDepth = n
+		}
+	}
+}
+
+// WithRecoveryRedaction sets the policy applied to the logged URL.
+// redact.Default is used when unset.
+func WithRecoveryRedaction(p *redact.Policy) RecoveryOption {
+	return func(c *recoveryConfig) { c.redaction = p }
+}
+
+// WithRecoveryTxIdHeader sets the header carrying the transaction ID on the
+// 500, X-Tx-Id by default. Use the first name given to WithTxIdHeaders.
+func WithRecoveryTxIdHeader(name string) RecoveryOption {
+	return func(c *recoveryConfig) {
+		if name != "" {
+			c.txIdHeader = name
+		}
+	}
+}
+
+// Recovery returns a middleware that recovers panics of the next handler.
+// The panic value and a trimmed stack are logged with log.Log, the span of
+// the request is marked as failed and, unless the handler already started
+// the response, a 500 carrying the X-Tx-Id header is sent.
+// http.ErrAbortHandler is re-panicked so net/http aborts the response.
+//
+// Install it inside TraceMiddleware so the panic is logged with the trace
+// and the 500 shows up in the response log line:
+//
+//	TraceMiddleware(l)(Recovery(l)(mux))
+func Recovery(l *slog.Logger, opts ...RecoveryOption) func(http.Handler) http.Handler {
+	cfg := &recoveryConfig{stackDepth: defaultStackDepth, txIdHeader: trace.TxIdHeader}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp := &utils.ResponseRecorder{ResponseWriter: w, Limit: -1}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				stack := panicStack(cfg.stackDepth)
+				cfg.recovered(l, resp, r, v, stack)
+			}()
+			next.ServeHTTP(resp, r)
+		})
+	}
+}
+
+func (c *recoveryConfig) recovered(l *slog.Logger, resp *utils.ResponseRecorder, r *http.Request, v any, stack string) {
+	ctx := r.Context()
+	err, ok := v.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", v)
+	}
+	if span, ok := trace.SpanFrom(ctx); ok {
+		span.RecordError(err)
+		span.SetStatus(trace.StatusError, "panic")
+	}
+	log.Log(l, ctx, slog.LevelError, "Panic recovered",
+		"panic", fmt.Sprint(v), "Url", c.policy().URL(r.URL), "method", r.Method, "stack", stack)
+	if c.hook != nil {
+		c.hook(r, v, stack)
+	}
+
+	started := resp.Status != 0 || resp.Size > 0 || resp.Flushes > 0
+	if resp.Hijacked || started {
+		return // too late for an error response, the client gets a truncated one
+	}
+	txId, _ := trace.TraceIdFrom(ctx)
+	if txId != "" && resp.Header().Get(c.txIdHeader) == "" {
+		resp.Header().Set(c.txIdHeader, txId)
+	}
+	status := http.StatusInternalServerError
+	if !c.problem {
+		http.Error(resp, http.StatusText(status), status)
+		return
+	}
+	problem.Write(resp, r, problem.New(status, ""))
+}
+
+func (c *recoveryConfig) policy() *redact.Policy {
+	if c.redaction != nil {
+		return c.redaction
+	}
+	return redact.Default()
+}
+
+// panicStack renders the stack of the panicking goroutine from the frame
+// that panicked, leaving out the runtime and recovery frames, and keeps at
+// most depth frames. It must be called from the deferred recover function.
+func panicStack(depth int) string {
+	pcs := make([]uintptr, 64+depth)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var all []runtime.Frame
+	start := 0
+	for {
+		frame, more := frames.Next()
+		all = append(all, frame)
+		if frame.Function == "runtime.gopanic" {
+			start = len(all)
+		}
+		if !more {
+			break
+		}
+	}
+	var b strings.Builder
+	kept := 0
+	for _, frame := range all[start:] {
+		if kept == 0 && strings.HasPrefix(frame.Function, "runtime.") {
+			continue // runtime.panicmem, runtime.sigpanic...
+		}
+		if kept == depth {
+			b.WriteString("...\n")
+			break
+		}
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		kept++
+	}
+	return b.String()
+}
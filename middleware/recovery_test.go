@@ -0,0 +1,142 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func boom(w http.ResponseWriter, r *http.Request) {
+	panic("boom")
+}
+
+func TestRecovery_LogsAndResponds(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	var hooked any
+	var hookStack string
+	h := middleware.TraceMiddleware(logger)(middleware.Recovery(logger, middleware.WithPanicHook(
+		func(r *http.Request, v any, stack string) { hooked, hookStack = v, stack }))(http.HandlerFunc(boom)))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/explode", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rr.Code)
+	}
+	txId := rr.Header().Get(trace.TxIdHeader)
+	if txId == "" {
+		t.Fatal("missing X-Tx-Id on the 500")
+	}
+	logs := logBuf.String()
+	for _, want := range []string{"level=ERROR msg=\"Panic recovered\" trace=" + txId, "panic=boom", "middleware_test.boom", "msg=Response", "status=500"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "runtime.gopanic") || strings.Contains(logs, "panicStack") {
+		t.Errorf("stack not trimmed:\n%s", logs)
+	}
+	if hooked != "boom" || !strings.HasPrefix(hookStack, "github.com/Guadalsistema/net-utils/middleware_test.boom") {
+		t.Errorf("hook got %v, stack:\n%s", hooked, hookStack)
+	}
+}
+
+func TestRecovery_ProblemResponse(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	h := middleware.TraceMiddleware(logger)(middleware.Recovery(logger, middleware.WithProblemResponse())(http.HandlerFunc(boom)))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var problem map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem["status"] != float64(500) || problem["traceId"] != rr.Header().Get(trace.TxIdHeader) {
+		t.Fatalf("unexpected problem: %s", rr.Body.String())
+	}
+}
+
+func TestRecovery_WithoutTrace(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	rr := httptest.NewRecorder()
+	middleware.Recovery(logger)(http.HandlerFunc(boom)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(logBuf.String(), "Panic recovered") {
+		t.Fatalf("status %d, logs:\n%s", rr.Code, logBuf.String())
+	}
+}
+
+func TestRecovery_RedactionAndTxIdHeader(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	h := middleware.Recovery(logger,
+		middleware.WithRecoveryRedaction(&redact.Policy{QueryParams: []string{"code"}}),
+		middleware.WithRecoveryTxIdHeader("X-Request-Id"))(http.HandlerFunc(boom))
+
+	ctx, span := trace.Start(context.Background(), "test")
+	defer span.End()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/cb?code=abc", nil).WithContext(ctx))
+
+	if got := rr.Header().Get("X-Request-Id"); got != span.SpanContext().TraceID.String() {
+		t.Fatalf("X-Request-Id = %q", got)
+	}
+	if rr.Header().Get(trace.TxIdHeader) != "" {
+		t.Fatalf("%s set on the 500", trace.TxIdHeader)
+	}
+	logs := logBuf.String()
+	if strings.Contains(logs, "code=abc") || !strings.Contains(logs, "code=%5BREDACTED%5D") {
+		t.Errorf("URL not redacted:\n%s", logs)
+	}
+}
+
+func TestRecovery_ResponseAlreadyStarted(t *testing.T) {
+	rr := httptest.NewRecorder()
+	middleware.Recovery(slog.New(slog.DiscardHandler))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("late")
+	})).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "partial" {
+		t.Fatalf("response rewritten: %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRecovery_RepanicsAbortHandler(t *testing.T) {
+	h := middleware.Recovery(slog.New(slog.DiscardHandler))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecovery_StackDepth(t *testing.T) {
+	var stack string
+	h := middleware.Recovery(slog.New(slog.DiscardHandler), middleware.WithStackDepth(1),
+		middleware.WithPanicHook(func(r *http.Request, v any, s string) { stack = s }))(http.HandlerFunc(boom))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if strings.Count(stack, "\n\t") != 1 || !strings.HasSuffix(stack, "...\n") {
+		t.Fatalf("stack not cut to one frame:\n%s", stack)
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo is shared by the middlewares of a request, so the inner ones
+// can report what happened to it to the outer ones: TraceMiddleware logs it
+// and Metrics labels by it.
+type requestInfo struct {
+	mu         sync.Mutex
+	timedOut   bool
+	pattern    string
+	pathValues []any // slog.Attr
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// withRequestInfo returns ctx carrying the requestInfo of the request,
+// adding one when the outer middlewares did not.
+func withRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	if info := requestInfoFrom(ctx); info != nil {
+		return ctx, info
+	}
+	info := &requestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+// markTimedOut records that the request of ctx ran out of time.
+func markTimedOut(ctx context.Context) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.timedOut = true
+		info.mu.Unlock()
+	}
+}
+
+// recordPattern passes on the pattern http.ServeMux matched for r, and the
+// path values it extracted. The mux sets them on the request it was given,
+// which the middlewares copying the request in front of it never see; they
+// call recordPattern once the handler returned so the route reaches the
+// outer ones.
+func recordPattern(r *http.Request) {
+	if r.Pattern == "" {
+		return
+	}
+	info := requestInfoFrom(r.Context())
+	if info == nil {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.pattern != "" {
+		return
+	}
+	info.pattern = r.Pattern
+	for _, name := range pathWildcards(r.Pattern) {
+		info.pathValues = append(info.pathValues, slog.String(name, r.PathValue(name)))
+	}
+}
+
+// route returns the ServeMux pattern matched for the request, empty when no
+// pattern matched, and the path values as slog.Attr.
+func (info *requestInfo) route() (pattern string, pathValues []any) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.pattern, info.pathValues
+}
+
+// pathWildcards returns the names of the wildcards of a ServeMux pattern.
+func pathWildcards(pattern string) []string {
+	var names []string
+	for {
+		start := strings.IndexByte(pattern, '{')
+		end := strings.IndexByte(pattern, '}')
+		if start < 0 || end < start {
+			return names
+		}
+		if name := strings.TrimSuffix(pattern[start+1:end], "..."); name != "$" {
+			names = append(names, name)
+		}
+		pattern = pattern[end+1:]
+	}
+}
+
+// routeLabel strips the method of a ServeMux pattern, leaving the route as
+// in the http.route attribute of OpenTelemetry.
+func routeLabel(pattern string) string {
+	if method, rest, ok := strings.Cut(pattern, " "); ok && !strings.Contains(method, "/") {
+		return strings.TrimLeft(rest, " \t")
+	}
+	return pattern
+}
+
+// outcome reports how a request ended early: its deadline passed, or the
+// client went away before the response was done.
+func (info *requestInfo) outcome(clientCtx context.Context) (timedOut, clientGone bool) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.timedOut {
+		return true, false
+	}
+	return false, errors.Is(clientCtx.Err(), context.Canceled)
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
+)
+
+// RequestTimeoutHeader lets clients ask for a shorter, or up to a configured
+// maximum longer, deadline, in seconds.
+const RequestTimeoutHeader = "Request-Timeout"
+
+// TimeoutOption configures Timeout.
+type TimeoutOption func(*timeoutConfig)
+
+type timeoutConfig struct {
+	routes    []string
+	routeD    []time.Duration
+	headerMax time.Duration
+	status    int
+}
+
+// WithRouteTimeout overrides the timeout of a path. A trailing "*" matches
+// any suffix; the longest match wins. A zero duration disables the deadline,
+// for streaming endpoints.
+func WithRouteTimeout(path string, d time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.routes = append(c.routes, path)
+		c.routeD = append(c.routeD, d)
+	}
+}
+
+// WithRequestTimeoutHeader honours the Request-Timeout header sent by
+// clients, capped by max.
+func WithRequestTimeoutHeader(max time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) { c.headerMax = max }
+}
+
+// WithTimeoutStatus sets the status of timed out requests:
+// http.StatusServiceUnavailable, the default, or http.StatusGatewayTimeout.
+func WithTimeoutStatus(status int) TimeoutOption {
+	return func(c *timeoutConfig) { c.status = status }
+}
+
+// timeout returns the deadline to apply to r, zero for none.
+func (c *timeoutConfig) timeout(r *http.Request, d time.Duration) time.Duration {
+	if i := longestMatch(c.routes, r.URL.Path); i >= 0 {
+		d = c.routeD[i]
+	}
+	if c.headerMax > 0 {
+		if v := strings.TrimSpace(r.Header.Get(RequestTimeoutHeader)); v != "" {
+			if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+				// compared before converting, so huge values and +Inf do
+				// not overflow into a negative duration
+				if secs >= c.headerMax.Seconds() {
+					d = c.headerMax
+				} else {
+					d = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+	return d
+}
+
+// Timeout returns a middleware putting a deadline on the request context,
+// d by default. Handlers are expected to give up once the context is done;
+// the middleware answers for them with a problem as soon as the deadline
+// passes, unless the response has started, and discards what they write
+// afterwards with http.ErrHandlerTimeout. Unlike http.TimeoutHandler the
+// response is not buffered, so streaming and flushing keep working.
+//
+// When it runs inside TraceMiddleware, the response log records the timeout.
+func Timeout(l *slog.Logger, d time.Duration, opts ...TimeoutOption) func(http.Handler) http.Handler {
+	cfg := &timeoutConfig{status: http.StatusServiceUnavailable}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := cfg.timeout(r, d)
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+			tw := &timeoutWriter{w: w, h: w.Header().Clone(), r: r, logger: l, timeout: d, status: cfg.status}
+			stop := context.AfterFunc(ctx, func() {
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.checkDeadline()
+			})
+			defer func() {
+				stop()
+				tw.mu.Lock()
+				if !tw.wroteHeader {
+					tw.checkDeadline() // the handler gave up without answering
+				}
+				tw.done = true
+				tw.mu.Unlock()
+			}()
+			next.ServeHTTP(tw, r)
+			recordPattern(r)
+		})
+	}
+}
+
+// timeoutWriter serialises the writes of the handler with the timeout
+// response. The handler gets its own header map, copied when the response
+// starts, so it never touches the headers being sent for it.
+type timeoutWriter struct {
+	w       http.ResponseWriter
+	h       http.Header
+	r       *http.Request
+	logger  *slog.Logger
+	timeout time.Duration
+	status  int
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	done        bool
+}
+
+// checkDeadline sends the timeout response once the deadline has passed.
+// It runs under mu, both when the context expires and before every write,
+// since the handler may see the context done before the former gets to run.
+func (tw *timeoutWriter) checkDeadline() bool {
+	if tw.timedOut {
+		return true
+	}
+	ctx := tw.r.Context()
+	if tw.done || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+	tw.timedOut = true
+	markTimedOut(ctx)
+	log.Log(tw.logger, ctx, slog.LevelWarn, "Request timed out", "Url", tw.r.URL.Path, "method", tw.r.Method, "timeout", tw.timeout, "responseStarted", tw.wroteHeader)
+	if !tw.wroteHeader {
+		problem.Error(tw.w, tw.r, tw.status, "request timed out")
+		http.NewResponseController(tw.w).Flush()
+	}
+	return true
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.checkDeadline() {
+		tw.writeHeader(code)
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader && code >= 200 {
+		return
+	}
+	dst := tw.w.Header()
+	clear(dst)
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	if code >= 200 {
+		tw.wroteHeader = true
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.checkDeadline() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+// FlushError flushes the response unless it timed out.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.checkDeadline() {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return http.NewResponseController(tw.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.w }
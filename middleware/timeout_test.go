@@ -0,0 +1,164 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+)
+
+// slow waits for the request context and reports what its late write got.
+func slow(writeErr chan<- error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Header().Set("X-Late", "1")
+		_, err := w.Write([]byte("late"))
+		writeErr <- err
+	})
+}
+
+func TestTimeout_AnswersOnDeadline(t *testing.T) {
+	var logBuf bytes.Buffer
+	var mu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&syncWriter{w: &logBuf, mu: &mu}, nil))
+	writeErr := make(chan error, 1)
+	h := middleware.TraceMiddleware(logger)(middleware.Timeout(logger, 20*time.Millisecond)(slow(writeErr)))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if err := <-writeErr; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Fatalf("late write error = %v", err)
+	}
+	if rr.Header().Get("X-Late") != "" || strings.Contains(rr.Body.String(), "late") {
+		t.Fatalf("late response leaked: %v %q", rr.Header(), rr.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	logs := logBuf.String()
+	if !strings.Contains(logs, `msg="Request timed out"`) || !strings.Contains(logs, "timeout=20ms") {
+		t.Fatalf("timeout not logged:\n%s", logs)
+	}
+	if !strings.Contains(logs, "status=503") || !strings.Contains(logs, "timedOut=true") || strings.Contains(logs, "streaming=true") {
+		t.Fatalf("response log does not record the timeout:\n%s", logs)
+	}
+}
+
+func TestTimeout_FastHandlerUntouched(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("no deadline on the request context")
+		}
+		w.Header().Set("X-Handler", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	h := middleware.Timeout(slog.New(slog.DiscardHandler), time.Second)(next)
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set("X-Outer", "1")
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusCreated || rr.Body.String() != "done" {
+		t.Fatalf("got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Handler") != "1" || rr.Header().Get("X-Outer") != "1" {
+		t.Fatalf("headers = %v", rr.Header())
+	}
+}
+
+func TestTimeout_StartedResponseIsKept(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+	})
+	h := middleware.Timeout(slog.New(slog.DiscardHandler), 10*time.Millisecond)(next)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Fatalf("got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTimeout_RoutesStatusAndHeader(t *testing.T) {
+	deadlines := make(chan time.Duration, 1)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := r.Context().Deadline()
+		if !ok {
+			deadlines <- 0
+			return
+		}
+		deadlines <- time.Until(d)
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+		}
+	})
+	h := middleware.Timeout(slog.New(slog.DiscardHandler), time.Minute,
+		middleware.WithRouteTimeout("/events/*", 0),
+		middleware.WithRouteTimeout("/slow", 10*time.Millisecond),
+		middleware.WithRequestTimeoutHeader(2*time.Second),
+		middleware.WithTimeoutStatus(http.StatusGatewayTimeout),
+	)(next)
+
+	serve := func(path, header string) (int, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set(middleware.RequestTimeoutHeader, header)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code, <-deadlines
+	}
+
+	if _, d := serve("/events/stream", ""); d != 0 {
+		t.Fatalf("disabled route has a deadline in %v", d)
+	}
+	if code, _ := serve("/slow", ""); code != http.StatusGatewayTimeout {
+		t.Fatalf("route timeout status = %d", code)
+	}
+	if _, d := serve("/", ""); d < 59*time.Second {
+		t.Fatalf("default deadline in %v", d)
+	}
+	if _, d := serve("/", "0.5"); d > 500*time.Millisecond || d < 400*time.Millisecond {
+		t.Fatalf("Request-Timeout 0.5 gave %v", d)
+	}
+	if _, d := serve("/", "3600"); d > 2*time.Second {
+		t.Fatalf("Request-Timeout not capped: %v", d)
+	}
+	for _, huge := range []string{"1e10", "inf"} {
+		if _, d := serve("/", huge); d <= 0 || d > 2*time.Second {
+			t.Fatalf("Request-Timeout %s gave %v, want the 2s cap", huge, d)
+		}
+	}
+	if _, d := serve("/", "soon"); d < 59*time.Second {
+		t.Fatalf("invalid Request-Timeout gave %v", d)
+	}
+}
+
+func TestTimeout_ClientDisconnectLogged(t *testing.T) {
+	var logBuf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	})
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	h := middleware.TraceMiddleware(logger)(middleware.Timeout(logger, time.Minute)(next))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	logs := logBuf.String()
+	if !strings.Contains(logs, "clientDisconnected=true") || strings.Contains(logs, "timedOut") {
+		t.Fatalf("disconnect not logged:\n%s", logs)
+	}
+}
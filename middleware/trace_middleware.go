@@ -2,103 +2,228 @@ package middleware
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/problem"
 	"github.com/Guadalsistema/net-utils/trace"
 	"github.com/Guadalsistema/net-utils/utils"
 )
 
 /* -------------------------------------------------------------------------- */
 
-// decompressGzip function to handle gzip decompression
-func decompressGzip(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-
-	return io.ReadAll(reader)
+// isEventStream reports whether the response is a Server-Sent Events stream.
+func isEventStream(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
 }
 
-// maxBodyLog limits how much of the body we copy for logging.
-// You can override it before you register the middleware.
-var maxBodyLog int64 = 1 << 20 // 1 MiB
-// ctxKeyRawBody is an unexported context key.
+// bodyLogging decides whether request and response bodies are captured and at
+// which level they are logged. By default this follows the debug level of the
+// logger. When sampling is configured, through trace.SetSampler or
+// WithSampler, bodies are captured for sampled requests only, whatever the
+// logger level: at debug level when it is enabled, at info level otherwise.
+func bodyLogging(l *slog.Logger, ctx context.Context, sc trace.SpanContext, sampling bool) (slog.Level, bool) {
+	debug := l.Enabled(ctx, slog.LevelDebug)
+	if _, ok := trace.ConfiguredSampler(); !ok && !sampling {
+		return slog.LevelDebug, debug
+	}
+	if !sc.IsSampled() {
+		return slog.LevelDebug, false
+	}
+	if debug {
+		return slog.LevelDebug, true
+	}
+	return slog.LevelInfo, true
+}
 
 // TraceMiddleware returns a fully-formed http.Handler middleware.
-// It captures the request body and response body, and logs them.
-// It also creates a unique transaction Idfor the request available in the request context.
-func TraceMiddleware(l *slog.Logger) func(http.Handler) http.Handler {
+// It captures the request body and response body, and logs them according to
+// their content: compressed bodies are decoded, forms are logged by field,
+// multipart uploads as a summary of their parts and binary data is skipped.
+// Streaming responses and hijacked connections are logged without a body.
+// It also opens a server span continuing the trace extracted by
+// trace.DefaultPropagator (or starting a new one), makes the span and
+// transaction Id available in the request context and echoes them on the
+// response.
+// When the request is routed by an http.ServeMux, the response log carries the
+// matched pattern and the path values, and the span is named after the route.
+// The response log tells requests that timed out, see Timeout, from those
+// whose client disconnected.
+// The behaviour can be tuned with TraceOption values.
+func TraceMiddleware(l *slog.Logger, opts ...TraceOption) func(http.Handler) http.Handler {
+	cfg := newTraceConfig(opts)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.skip(r.URL.Path) && next != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
 			logger := l
 			start := time.Now()
+			policy := cfg.policy()
+			bodies := bodyLog{policy: policy, limit: cfg.maxBodyLog, preview: cfg.binaryPreview}
 			/* ---------- advance work: Tx-Id+ capture body ---------- */
-			txId := utils.RandomKey(8)
+			propagator := cfg.activePropagator()
+			carrier := trace.HeaderCarrier(r.Header)
+			parentCtx := r.Context()
+			if cfg.trustIncoming {
+				parentCtx = propagator.Extract(parentCtx, carrier)
+				// the first configured header wins, so apply it last
+				for i := len(cfg.txIdHeaders) - 1; i >= 0; i-- {
+					parentCtx = trace.TxIdPropagator{Header: cfg.txIdHeaders[i]}.Extract(parentCtx, carrier)
+				}
+			} else if b := trace.BaggageFrom(propagator.Extract(parentCtx, carrier)); b.Len() > 0 {
+				parentCtx = trace.WithBaggage(parentCtx, b)
+			}
+			if _, ok := trace.TraceIdFrom(parentCtx); !ok && cfg.idGenerator != nil {
+				parentCtx = trace.WithTraceId(parentCtx, cfg.idGenerator())
+			}
+			startAttrs := []slog.Attr{
+				slog.String("http.request.method", r.Method),
+				slog.String("url.path", r.URL.Path),
+				slog.String("client.address", ClientAddress(r)),
+			}
+			var pattern string
+			if cfg.mux != nil {
+				if _, pattern = cfg.mux.Handler(r); pattern != "" {
+					startAttrs = append(startAttrs, slog.String("http.route", routeLabel(pattern)))
+				}
+			}
+			startOpts := []trace.StartOption{
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithStartTime(start),
+				trace.WithAttributes(startAttrs...),
+			}
+			if cfg.sampler != nil {
+				startOpts = append(startOpts, trace.WithSampler(cfg.sampler))
+			}
+			spanCtx, span := trace.Start(parentCtx, r.Method, startOpts...)
+			defer span.End()
+			txId, _ := trace.TraceIdFrom(spanCtx)
 
 			// Create new request with modified context
-			newReq := r.WithContext(trace.WithTraceId(r.Context(), txId))
-			resp := &utils.ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+			spanCtx, info := withRequestInfo(spanCtx)
+			newReq := r.WithContext(spanCtx)
+			resp := &utils.ResponseRecorder{ResponseWriter: w, Status: http.StatusOK, Limit: cfg.maxBodyLog, TailLimit: cfg.tailBodyLog}
 			ctx := newReq.Context()
 
-			/* ----------- capture request body via TeeReader ----------- */
-			var logbuf bytes.Buffer
-			tee := io.TeeReader(newReq.Body, &utils.CappedWriter{Writer: &logbuf, Remain: maxBodyLog})
-			newReq.Body = io.NopCloser(tee)
-
-			if err := log.ContextDebug(logger, ctx, "Request", "Url", newReq.URL.String(), "method", newReq.Method); err != nil {
+			reqArgs := []any{"Url", policy.URL(newReq.URL), "method", newReq.Method, "client", ClientAddress(newReq)}
+			if pattern != "" {
+				reqArgs = append(reqArgs, "pattern", pattern)
+			}
+			if err := log.ContextDebug(logger, ctx, "Request", reqArgs...); err != nil {
 				slog.ErrorContext(ctx, "Failed to log request", "error", err)
 				return
 			}
 
-			if logger.Enabled(ctx, slog.LevelDebug) {
-				payload, err := io.ReadAll(newReq.Body)
+			bodyLevel, captureBodies := bodyLogging(logger, ctx, span.SpanContext(), cfg.sampler != nil)
+			if !captureBodies {
+				resp.Limit = -1 // count bytes only
+				resp.TailLimit = 0
+			}
+			if captureBodies && newReq.Body != nil && cfg.captureType(newReq.Header.Get("Content-Type")) {
+				/* ----------- capture the request body prefix ----------- */
+				prefix, err := io.ReadAll(io.LimitReader(newReq.Body, cfg.maxBodyLog))
 				if err != nil {
 					log.ContextError(logger, ctx, "Failed to read request body", "error", err)
-					http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					problem.Error(resp, newReq, http.StatusInternalServerError, "")
 					return
 				}
 				// Read request for log
-				log.ContextDebug(logger, ctx, "Request body", "method", newReq.Method, "size", logbuf.Len(), log.LogHeaders(newReq.Header), "body", utils.TruncateString(logbuf.String(), maxBodyLog))
-				newReq.Body = io.NopCloser(bytes.NewReader(payload)) // volver a ponerlo
+				args := []any{"method", newReq.Method, "size", len(prefix), log.LogHeadersWith(policy, newReq.Header)}
+				log.ContextLog(logger, ctx, bodyLevel, "Request body", append(args, bodies.attrs(newReq.Header, prefix)...)...)
+				// volver a ponerlo: the logged prefix followed by the unread rest
+				newReq.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(prefix), newReq.Body), newReq.Body}
 			}
 
 			if next == nil {
 				log.ContextError(logger, ctx, "Server error", "error", "next HTTP handler is nil.")
-				http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				problem.Error(resp, newReq, http.StatusInternalServerError, "")
 				return
 			}
 			if newReq.Body == nil {
 				log.ContextError(logger, ctx, "Server error", "error", "Request body is nil.")
-				http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				problem.Error(resp, newReq, http.StatusInternalServerError, "")
 				return
 			}
 
-			w.Header().Set("X-Tx-Id", txId) // Set transaction ID in response header
+			// Only the IDs are echoed; baggage and the other formats of the
+			// propagator stay server side
+			w.Header().Set(cfg.txIdHeaders[0], txId) // Set transaction ID in response header
+			w.Header().Set(trace.TraceparentHeader, span.SpanContext().Traceparent())
 
 			// Use the new request with modified context
 			next.ServeHTTP(resp, newReq)
+			recordPattern(newReq)
 
 			/* ---------- log outgoing response ---------- */
 			elapsed := time.Since(start)
-			log.ContextInfo(logger, newReq.Context(), "Response", "Url", newReq.URL.String(), "method", newReq.Method, "status", resp.Status, "size", resp.Buf.Len(), "elapsed", elapsed)
-			if l.Enabled(ctx, slog.LevelDebug) {
-				if resp.Header().Get("Content-Encoding") == "gzip" {
-					if decompressedBody, err := decompressGzip(resp.Buf.Bytes()); err == nil {
-						log.ContextDebug(logger, newReq.Context(), "Response body", "size", len(decompressedBody), log.LogHeaders(resp.Header()), "body", utils.TruncateString(string(decompressedBody), maxBodyLog))
-					} else {
-						log.ContextError(logger, ctx, "Failed to decompress response body", "error", err)
+			span.SetAttributes(
+				slog.Int("http.response.status_code", resp.Status),
+				slog.Int64("http.response.body.size", resp.Size),
+			)
+			matched, pathValues := info.route()
+			if matched != "" {
+				pattern = matched
+			}
+			if pattern != "" {
+				span.SetName(newReq.Method + " " + routeLabel(pattern))
+				span.SetAttributes(slog.String("http.route", routeLabel(pattern)))
+			}
+			if resp.Status >= http.StatusInternalServerError {
+				span.SetStatus(trace.StatusError, http.StatusText(resp.Status))
+			}
+			if resp.Hijacked {
+				// The handler owns the connection now: there is no response to log.
+				read, written := resp.HijackedBytes()
+				span.SetAttributes(slog.String("network.protocol.upgrade", newReq.Header.Get("Upgrade")))
+				log.ContextInfo(logger, newReq.Context(), "Hijacked connection", "Url", policy.URL(newReq.URL), "method", newReq.Method, "upgrade", newReq.Header.Get("Upgrade"), "bytesRead", read, "bytesWritten", written, "elapsed", elapsed)
+				return
+			}
+			level := cfg.statusLevel(resp.Status)
+			args := []any{"Url", policy.URL(newReq.URL), "method", newReq.Method, "client", ClientAddress(newReq), "status", resp.Status, "size", resp.Size, "elapsed", elapsed}
+			if pattern != "" {
+				args = append(args, "pattern", pattern)
+			}
+			if len(pathValues) > 0 {
+				args = append(args, slog.Group("pathValues", pathValues...))
+			}
+			timedOut, clientGone := info.outcome(r.Context())
+			switch {
+			case timedOut:
+				args = append(args, "timedOut", true)
+			case clientGone:
+				args = append(args, "clientDisconnected", true)
+			}
+			// the timeout response is flushed, that does not make it a stream
+			streaming := resp.Flushes > 0 && !timedOut || isEventStream(resp.Header())
+			if streaming {
+				log.ContextLog(logger, newReq.Context(), level, "Response", append(args, "streaming", true, "flushes", resp.Flushes)...)
+				return
+			}
+			log.ContextLog(logger, newReq.Context(), level, "Response", args...)
+			if captureBodies && cfg.captureType(resp.Header().Get("Content-Type")) {
+				args := []any{"size", resp.Size, log.LogHeadersWith(policy, resp.Header())}
+				args = append(args, bodies.attrs(resp.Header(), resp.Buf.Bytes())...)
+				if resp.Truncated() {
+					args = append(args, "truncated", true)
+					contentType := resp.Header().Get("Content-Type")
+					mediaType, _, _ := mime.ParseMediaType(contentType)
+					tail := resp.Tail()
+					if len(tail) > 0 && resp.Header().Get("Content-Encoding") == "" && isTextual(mediaType, tail) {
+						args = append(args, "bodyTail", bodies.text(contentType, tail))
 					}
-				} else {
-					// Normal logging for non-gzipped responses
-					log.ContextDebug(logger, newReq.Context(), "Response body", "size", resp.Buf.Len(), log.LogHeaders(resp.Header()), "body", utils.TruncateString(resp.Buf.String(), maxBodyLog))
 				}
+				log.ContextLog(logger, newReq.Context(), bodyLevel, "Response body", args...)
 			}
 		})
 	}
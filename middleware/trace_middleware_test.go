@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"log/slog"
 
 	"github.com/Guadalsistema/net-utils/middleware"
 	"github.com/Guadalsistema/net-utils/trace"
+	"github.com/Guadalsistema/net-utils/utils"
 )
 
 func TestLoggingMetaMiddlewareDebug(t *testing.T) {
@@ -192,3 +195,372 @@ func TestMetaMiddleware_ContextHandling(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rr.Code)
 	}
 }
+
+func TestTraceMiddleware_ContinuesW3CTrace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var got trace.SpanContext
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := trace.SpanContextFrom(r.Context())
+		if !ok {
+			t.Fatal("span context missing from request context")
+		}
+		got = sc
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("traceparent", parent)
+	req.Header.Set("tracestate", "rojo=00f067aa0ba902b7")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	if got.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected trace to be continued, got trace ID %s", got.TraceID)
+	}
+	if got.SpanID.String() == "00f067aa0ba902b7" {
+		t.Errorf("expected a new child span ID")
+	}
+	if !got.IsSampled() {
+		t.Errorf("expected sampled flag to be inherited")
+	}
+	if txId := rr.Header().Get("X-Tx-Id"); txId != got.TraceID.String() {
+		t.Errorf("X-Tx-Id = %q, want %q", txId, got.TraceID)
+	}
+	if tp := rr.Header().Get("traceparent"); tp != got.Traceparent() {
+		t.Errorf("traceparent = %q, want %q", tp, got.Traceparent())
+	}
+	if got.TraceState.String() != "rojo=00f067aa0ba902b7" {
+		t.Errorf("tracestate = %q", got.TraceState.String())
+	}
+	if ts := rr.Header().Get("tracestate"); ts != "" {
+		t.Errorf("tracestate echoed on the response: %q", ts)
+	}
+}
+
+func TestTraceMiddleware_InvalidTraceparentStartsNewTrace(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	sc, err := trace.ParseTraceparent(rr.Header().Get("traceparent"))
+	if err != nil {
+		t.Fatalf("response traceparent invalid: %v", err)
+	}
+	if sc.TraceID.String() != rr.Header().Get("X-Tx-Id") {
+		t.Errorf("X-Tx-Id does not match response traceparent")
+	}
+}
+
+func TestTraceMiddleware_ServerSpan(t *testing.T) {
+	var span *trace.Span
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := trace.SpanFrom(r.Context())
+		if !ok {
+			t.Fatal("server span missing from request context")
+		}
+		span = s
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	if span.Kind() != trace.SpanKindServer {
+		t.Errorf("kind = %s, want server", span.Kind())
+	}
+	if span.Parent().SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s", span.Parent().SpanID)
+	}
+	if span.IsRecording() {
+		t.Errorf("server span should be ended after the request")
+	}
+	if span.Status().Code != trace.StatusError {
+		t.Errorf("5xx response should mark the span as failed")
+	}
+}
+
+func TestTraceMiddleware_ConfiguredPropagator(t *testing.T) {
+	trace.SetDefaultPropagator(trace.NewCompositePropagator(trace.TxIdPropagator{}, trace.B3SinglePropagator{}))
+	t.Cleanup(func() { trace.SetDefaultPropagator(nil) })
+
+	var got trace.SpanContext
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = trace.SpanContextFrom(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("b3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	if got.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("B3 trace was not continued, got %s", got.TraceID)
+	}
+	if b3 := rr.Header().Get("b3"); b3 != "" {
+		t.Errorf("b3 response header %q should not be sent", b3)
+	}
+	if tp := rr.Header().Get("traceparent"); tp != got.Traceparent() {
+		t.Errorf("traceparent = %q, want %q", tp, got.Traceparent())
+	}
+	if rr.Header().Get("X-Tx-Id") != got.TraceID.String() {
+		t.Errorf("X-Tx-Id = %q", rr.Header().Get("X-Tx-Id"))
+	}
+}
+
+func TestTraceMiddleware_LegacyTxId(t *testing.T) {
+	var gotTxId string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTxId, _ = trace.TraceIdFrom(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Tx-Id", "abc12345")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	if gotTxId != "abc12345" || rr.Header().Get("X-Tx-Id") != "abc12345" {
+		t.Errorf("legacy transaction ID not continued: context %q, header %q", gotTxId, rr.Header().Get("X-Tx-Id"))
+	}
+}
+
+func TestTraceMiddleware_Baggage(t *testing.T) {
+	var tenant string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ = trace.BaggageFrom(r.Context()).Get("tenant")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("baggage", "tenant=acme")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(slog.Default())(next).ServeHTTP(rr, req)
+
+	if tenant != "acme" {
+		t.Errorf("baggage not propagated to the handler, got %q", tenant)
+	}
+	if b := rr.Header().Get("baggage"); b != "" {
+		t.Errorf("baggage echoed on the response: %q", b)
+	}
+}
+
+func TestTraceMiddleware_SamplingControlsBodies(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler trace.Sampler
+		path    string
+		want    bool
+	}{
+		{"sampled", trace.AlwaysSample(), "/ping", true},
+		{"not sampled", trace.NeverSample(), "/ping", false},
+		{"route rule", trace.RuleBased(trace.AlwaysSample(), trace.Rule{Route: "/healthz", Sampler: trace.NeverSample()}), "/healthz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace.SetSampler(tt.sampler)
+			t.Cleanup(func() { trace.SetSampler(nil) })
+
+			var logBuf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				w.Write([]byte("pong"))
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("ping"))
+			rr := httptest.NewRecorder()
+
+			middleware.TraceMiddleware(logger)(next).ServeHTTP(rr, req)
+
+			logged := strings.Contains(logBuf.String(), "body=ping") && strings.Contains(logBuf.String(), "body=pong")
+			if logged != tt.want {
+				t.Errorf("bodies logged = %v, want %v:\n%s", logged, tt.want, logBuf.String())
+			}
+			sampled := strings.HasSuffix(rr.Header().Get("traceparent"), "-01")
+			if sampled != tt.want {
+				t.Errorf("response traceparent %q does not carry the decision", rr.Header().Get("traceparent"))
+			}
+		})
+	}
+}
+
+func TestTraceMiddleware_Redaction(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=s3cr3t")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok3n","user":"ann"}`))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login?api_key=k3y&x=1", strings.NewReader(`{"user":"ann","password":"hunter2"}`))
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	middleware.TraceMiddleware(logger)(next).ServeHTTP(rr, req)
+
+	logs := logBuf.String()
+	for _, leak := range []string{"hunter2", "tok3n", "s3cr3t", "k3y", "Bearer abc"} {
+		if strings.Contains(logs, leak) {
+			t.Errorf("%q leaked into logs:\n%s", leak, logs)
+		}
+	}
+	if !strings.Contains(logs, "ann") || !strings.Contains(logs, "x=1") {
+		t.Errorf("non-sensitive data should still be logged:\n%s", logs)
+	}
+}
+
+func TestTraceMiddleware_LargeResponseIsNotBuffered(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	const size = 3 << 20 // larger than the 1 MiB log cap
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	var recorder *utils.ResponseRecorder
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder = w.(*utils.ResponseRecorder)
+		for written := 0; written < size; written += len(chunk) {
+			w.Write(chunk)
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	middleware.TraceMiddleware(logger)(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/download", nil))
+
+	if rr.Body.Len() != size {
+		t.Fatalf("client received %d bytes, want %d", rr.Body.Len(), size)
+	}
+	if recorder.Buf.Len() > 1<<20 {
+		t.Errorf("recorder kept %d bytes, want at most the 1 MiB cap", recorder.Buf.Len())
+	}
+	logs := logBuf.String()
+	if !strings.Contains(logs, "size=3145728") || !strings.Contains(logs, "truncated=true") {
+		t.Errorf("expected total size and truncation in logs")
+	}
+}
+
+func TestTraceMiddleware_ServerSentEvents(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("data: tick\n\n"))
+			if err := rc.Flush(); err != nil {
+				t.Fatalf("flush through middleware failed: %v", err)
+			}
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	middleware.TraceMiddleware(logger)(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if !rr.Flushed {
+		t.Errorf("flush did not reach the client writer")
+	}
+	logs := logBuf.String()
+	if !strings.Contains(logs, "streaming=true") || !strings.Contains(logs, "flushes=3") {
+		t.Errorf("expected streaming response log:\n%s", logs)
+	}
+	if strings.Contains(logs, "Response body") {
+		t.Errorf("streams must not be logged as a body:\n%s", logs)
+	}
+}
+
+func TestTraceMiddleware_Hijack(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	done := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack through middleware failed: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"))
+		conn.Close()
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		middleware.TraceMiddleware(logger)(next).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	io.ReadAll(conn)
+	<-done
+
+	logs := logBuf.String()
+	if !strings.Contains(logs, "Hijacked connection") || !strings.Contains(logs, "upgrade=websocket") || !strings.Contains(logs, "bytesWritten=56") {
+		t.Errorf("expected hijacked connection log:\n%s", logs)
+	}
+	if strings.Contains(logs, "msg=Response") {
+		t.Errorf("hijacked connections must not log a response:\n%s", logs)
+	}
+}
+
+func TestTraceMiddleware_RoutePattern(t *testing.T) {
+	exp := trace.NewInMemoryExporter()
+	trace.SetProcessor(trace.NewSimpleProcessor(exp))
+	t.Cleanup(func() { trace.SetProcessor(nil) })
+
+	var logBuf bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orgs/{org}/files/{path...}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {})
+	// Timeout copies the request between TraceMiddleware and the mux
+	h := middleware.TraceMiddleware(slog.New(slog.NewTextHandler(&logBuf, nil)))(middleware.Timeout(slog.Default(), time.Minute)(mux))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orgs/acme/files/a/b.txt", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	lines := strings.Split(strings.TrimSpace(logBuf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines:\n%s", len(lines), logBuf.String())
+	}
+	if !strings.HasSuffix(lines[0], `pattern="GET /orgs/{org}/files/{path...}" pathValues.org=acme pathValues.path=a/b.txt`) {
+		t.Errorf("pattern not logged: %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `pattern="GET /{$}"`) {
+		t.Errorf("pattern not logged: %s", lines[1])
+	}
+	if strings.Contains(lines[2], "pattern") {
+		t.Errorf("unmatched request logged a pattern: %s", lines[2])
+	}
+
+	spans := exp.Spans()
+	if len(spans) != 3 || spans[0].Name != "GET /orgs/{org}/files/{path...}" || spans[2].Name != http.MethodGet {
+		t.Fatalf("span names: %+v", spans)
+	}
+	var route string
+	for _, a := range spans[0].Attributes {
+		if a.Key == "http.route" {
+			route = a.Value.String()
+		}
+	}
+	if route != "/orgs/{org}/files/{path...}" {
+		t.Errorf("http.route = %q", route)
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+// DefaultMaxBodyLog limits how much of the request and response bodies are
+// kept for logging unless WithMaxBodyLog says otherwise.
+const DefaultMaxBodyLog int64 = 1 << 20 // 1 MiB
+
+// TraceOption configures TraceMiddleware.
+type TraceOption func(*traceConfig)
+
+type traceConfig struct {
+	maxBodyLog    int64
+	tailBodyLog   int64
+	txIdHeaders   []string
+	idGenerator   func() string
+	trustIncoming bool
+	skipPaths     []string
+	levels        [3]slog.Level // 1xx-3xx, 4xx, 5xx
+	captureTypes  []string
+	binaryPreview int
+	sampler       trace.Sampler
+	propagator    trace.Propagator
+	redaction     *redact.Policy
+	mux           *http.ServeMux
+}
+
+func newTraceConfig(opts []TraceOption) *traceConfig {
+	cfg := &traceConfig{
+		maxBodyLog:    DefaultMaxBodyLog,
+		txIdHeaders:   []string{trace.TxIdHeader},
+		trustIncoming: true,
+		levels:        [3]slog.Level{slog.LevelInfo, slog.LevelInfo, slog.LevelInfo},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithMaxBodyLog sets how many bytes of each body are captured and logged.
+func WithMaxBodyLog(n int64) TraceOption {
+	return func(c *traceConfig) {
+		if n > 0 {
+			c.maxBodyLog = n
+		}
+	}
+}
+
+// WithTailBodyLog keeps the last n bytes of response bodies larger than the
+// capture limit and logs them as "bodyTail".
+func WithTailBodyLog(n int64) TraceOption {
+	return func(c *traceConfig) { c.tailBodyLog = n }
+}
+
+// WithTxIdHeaders sets the headers carrying the transaction ID. Incoming
+// requests are checked in order and the first name is echoed on the response.
+// The default is X-Tx-Id.
+func WithTxIdHeaders(names ...string) TraceOption {
+	return func(c *traceConfig) {
+		if len(names) > 0 {
+			c.txIdHeaders = names
+		}
+	}
+}
+
+// WithIdGenerator generates the transaction ID of requests that do not carry
+// one. By default the trace ID of the server span is used.
+func WithIdGenerator(gen func() string) TraceOption {
+	return func(c *traceConfig) { c.idGenerator = gen }
+}
+
+// WithTrustIncoming controls whether trace and transaction IDs sent by the
+// client are continued. When false, every request starts a new trace; baggage
+// is still accepted.
+func WithTrustIncoming(trust bool) TraceOption {
+	return func(c *traceConfig) { c.trustIncoming = trust }
+}
+
+// WithSkipPaths lists request paths that are passed straight to the next
+// handler, without span or logs. A trailing "*" matches any suffix.
+func WithSkipPaths(paths ...string) TraceOption {
+	return func(c *traceConfig) { c.skipPaths = append(c.skipPaths, paths...) }
+}
+
+// WithStatusLevels sets the level of the response log line for successful
+// (below 400), client error (4xx) and server error (5xx) responses. Everything
+// is logged at info level by default.
+func WithStatusLevels(success, clientError, serverError slog.Level) TraceOption {
+	return func(c *traceConfig) { c.levels = [3]slog.Level{success, clientError, serverError} }
+}
+
+// WithCaptureContentTypes restricts body logging to the given media types.
+// A type ending in "/" matches a whole family, e.g. "text/".
+func WithCaptureContentTypes(types ...string) TraceOption {
+	return func(c *traceConfig) { c.captureTypes = append(c.captureTypes, types...) }
+}
+
+// WithBinaryPreview logs the first n bytes of binary bodies, hex encoded.
+// Binary bodies are only described by their size and type by default.
+func WithBinaryPreview(n int) TraceOption {
+	return func(c *traceConfig) { c.binaryPreview = n }
+}
+
+// WithSampler makes the sampling decisions of the middleware with s instead
+// of the sampler installed with trace.SetSampler. Bodies are then captured
+// for sampled requests only.
+func WithSampler(s trace.Sampler) TraceOption {
+	return func(c *traceConfig) { c.sampler = s }
+}
+
+// WithPropagator sets the propagator used to extract the incoming trace
+// context. trace.DefaultPropagator is used when unset. Responses carry the
+// transaction ID and traceparent headers only, whatever the propagator.
+func WithPropagator(p trace.Propagator) TraceOption {
+	return func(c *traceConfig) { c.propagator = p }
+}
+
+// WithRedaction sets the policy applied to logged headers, URLs and bodies.
+// redact.Default is used when unset.
+func WithRedaction(p *redact.Policy) TraceOption {
+	return func(c *traceConfig) { c.redaction = p }
+}
+
+// WithServeMux resolves the route of requests against mux before the span
+// starts, so sampling rules (trace.Rule) can match on the pattern through the
+// http.route attribute and the request log carries it. Without it the
+// pattern is only known, and logged, once the handler ran.
+func WithServeMux(mux *http.ServeMux) TraceOption {
+	return func(c *traceConfig) { c.mux = mux }
+}
+
+func (c *traceConfig) activePropagator() trace.Propagator {
+	if c.propagator != nil {
+		return c.propagator
+	}
+	return trace.DefaultPropagator()
+}
+
+func (c *traceConfig) policy() *redact.Policy {
+	if c.redaction != nil {
+		return c.redaction
+	}
+	return redact.Default()
+}
+
+func (c *traceConfig) skip(path string) bool {
+	for _, p := range c.skipPaths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *traceConfig) statusLevel(status int) slog.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return c.levels[2]
+	case status >= http.StatusBadRequest:
+		return c.levels[1]
+	}
+	return c.levels[0]
+}
+
+// captureType reports whether a body with the given Content-Type is logged.
+func (c *traceConfig) captureType(contentType string) bool {
+	if len(c.captureTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	for _, t := range c.captureTypes {
+		t = strings.ToLower(t)
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
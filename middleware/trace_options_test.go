@@ -0,0 +1,252 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func debugLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestTraceOptions_MaxAndTailBodyLog(t *testing.T) {
+	var logBuf bytes.Buffer
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789abcdefghij"))
+	})
+	h := middleware.TraceMiddleware(debugLogger(&logBuf),
+		middleware.WithMaxBodyLog(4), middleware.WithTailBodyLog(3))(next)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Body.String() != "0123456789abcdefghij" {
+		t.Fatalf("client got %q", rr.Body.String())
+	}
+	logs := logBuf.String()
+	for _, want := range []string{"body=0123", "truncated=true", "bodyTail=hij"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestTraceOptions_TxIdHeaders(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = trace.TraceIdFrom(r.Context())
+	})
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler),
+		middleware.WithTxIdHeaders("X-Request-Id", "X-Correlation-Id"))(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Correlation-Id", "corr-1")
+	req.Header.Set("X-Request-Id", "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if got != "req-1" {
+		t.Fatalf("tx id = %q, want req-1", got)
+	}
+	if rr.Header().Get("X-Request-Id") != "req-1" {
+		t.Fatalf("response header = %q", rr.Header().Get("X-Request-Id"))
+	}
+}
+
+func TestTraceOptions_IdGenerator(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = trace.TraceIdFrom(r.Context())
+	})
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler),
+		middleware.WithIdGenerator(func() string { return "generated" }))(next)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got != "generated" || rr.Header().Get(trace.TxIdHeader) != "generated" {
+		t.Fatalf("tx id = %q, header = %q", got, rr.Header().Get(trace.TxIdHeader))
+	}
+
+	// an incoming ID is kept
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(trace.TxIdHeader, "incoming")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "incoming" {
+		t.Fatalf("tx id = %q, want incoming", got)
+	}
+}
+
+func TestTraceOptions_UntrustedIncoming(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var sc trace.SpanContext
+	var txId string
+	var bag trace.Baggage
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, _ = trace.SpanContextFrom(r.Context())
+		txId, _ = trace.TraceIdFrom(r.Context())
+		bag = trace.BaggageFrom(r.Context())
+	})
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler), middleware.WithTrustIncoming(false))(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(trace.TraceparentHeader, traceparent)
+	req.Header.Set(trace.TxIdHeader, "spoofed")
+	req.Header.Set(trace.BaggageHeader, "tenant=acme")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if sc.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatal("incoming trace was continued")
+	}
+	if txId == "spoofed" {
+		t.Fatal("incoming transaction ID was used")
+	}
+	if v, _ := bag.Get("tenant"); v != "acme" {
+		t.Fatalf("baggage lost: %q", bag.String())
+	}
+}
+
+func TestTraceOptions_PropagatorNotEchoed(t *testing.T) {
+	p := trace.NewCompositePropagator(trace.TraceContextPropagator{}, trace.BaggagePropagator{},
+		trace.B3SinglePropagator{}, trace.B3MultiPropagator{}, trace.JaegerPropagator{})
+	var sc trace.SpanContext
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, _ = trace.SpanContextFrom(r.Context())
+	})
+	h := middleware.TraceMiddleware(slog.New(slog.DiscardHandler), middleware.WithPropagator(p))(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(trace.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(trace.BaggageHeader, "tenant=acme")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	for _, name := range []string{trace.BaggageHeader, trace.B3SingleHeader, trace.B3TraceIdHeader, trace.JaegerHeader, trace.TracestateHeader} {
+		if v := rr.Header().Get(name); v != "" {
+			t.Errorf("%s echoed on the response: %q", name, v)
+		}
+	}
+	if got := rr.Header().Get(trace.TraceparentHeader); got != sc.Traceparent() {
+		t.Errorf("traceparent = %q, want %q", got, sc.Traceparent())
+	}
+	if got := rr.Header().Get(trace.TxIdHeader); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("%s = %q", trace.TxIdHeader, got)
+	}
+}
+
+func TestTraceOptions_SkipPaths(t *testing.T) {
+	var logBuf bytes.Buffer
+	var traced bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, traced = trace.SpanFrom(r.Context())
+	})
+	h := middleware.TraceMiddleware(debugLogger(&logBuf), middleware.WithSkipPaths("/healthz", "/metrics/*"))(next)
+
+	for _, path := range []string{"/healthz", "/metrics/go"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if traced || rr.Header().Get(trace.TxIdHeader) != "" {
+			t.Errorf("%s was traced", path)
+		}
+	}
+	if logBuf.Len() != 0 {
+		t.Fatalf("skipped paths were logged:\n%s", logBuf.String())
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz/deep", nil))
+	if !traced {
+		t.Fatal("/healthz/deep should be traced")
+	}
+}
+
+func TestTraceOptions_StatusLevels(t *testing.T) {
+	cases := []struct {
+		status int
+		level  string
+	}{
+		{http.StatusOK, "level=INFO"},
+		{http.StatusNotFound, "level=WARN"},
+		{http.StatusBadGateway, "level=ERROR"},
+	}
+	for _, c := range cases {
+		var logBuf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(c.status) })
+		h := middleware.TraceMiddleware(logger,
+			middleware.WithStatusLevels(slog.LevelInfo, slog.LevelWarn, slog.LevelError))(next)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if !strings.Contains(logBuf.String(), c.level+" msg=Response") {
+			t.Errorf("status %d: want %s, got:\n%s", c.status, c.level, logBuf.String())
+		}
+	}
+}
+
+func TestTraceOptions_CaptureContentTypes(t *testing.T) {
+	var logBuf bytes.Buffer
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("PNGDATA"))
+	})
+	h := middleware.TraceMiddleware(debugLogger(&logBuf),
+		middleware.WithCaptureContentTypes("application/json", "text/"))(next)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := logBuf.String()
+	if !strings.Contains(logs, "msg=\"Request body\"") {
+		t.Errorf("JSON request body not logged:\n%s", logs)
+	}
+	if strings.Contains(logs, "PNGDATA") || strings.Contains(logs, "Response body") {
+		t.Errorf("image response body logged:\n%s", logs)
+	}
+}
+
+func TestTraceOptions_SamplerAndRedaction(t *testing.T) {
+	var logBuf bytes.Buffer
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret-response"))
+	})
+	policy := &redact.Policy{QueryParams: []string{"code"}}
+	h := middleware.TraceMiddleware(debugLogger(&logBuf),
+		middleware.WithSampler(trace.NeverSample()), middleware.WithRedaction(policy))(next)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cb?code=abc", nil))
+
+	logs := logBuf.String()
+	if strings.Contains(logs, "secret-response") {
+		t.Errorf("body logged for unsampled request:\n%s", logs)
+	}
+	if strings.Contains(logs, "code=abc") || !strings.Contains(logs, "code=%5BREDACTED%5D") {
+		t.Errorf("query not redacted:\n%s", logs)
+	}
+}
+
+func TestTraceOptions_ServeMuxSamplingRules(t *testing.T) {
+	var logBuf bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+	sampler := trace.RuleBased(trace.AlwaysSample(), trace.Rule{Method: "GET", Route: "/users/{id}", Sampler: trace.NeverSample()})
+	h := middleware.TraceMiddleware(debugLogger(&logBuf), middleware.WithSampler(sampler), middleware.WithServeMux(mux))(mux)
+
+	for path, sampled := range map[string]bool{"/users/42": false, "/health": true} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := strings.HasSuffix(rr.Header().Get("traceparent"), "-01"); got != sampled {
+			t.Errorf("%s sampled = %v, want %v", path, got, sampled)
+		}
+	}
+	if !strings.Contains(logBuf.String(), `Url=/users/42 method=GET client=192.0.2.1 pattern="GET /users/{id}"`+"\n") {
+		t.Errorf("request log lacks the pattern:\n%s", logBuf.String())
+	}
+}
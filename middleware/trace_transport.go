@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// TraceTransport is the client side counterpart of TraceMiddleware. It opens
+// a client span for every outgoing request, injects the trace from the request
+// context into the outgoing headers using its Propagator and logs request and
+// response.
+type TraceTransport struct {
+	Base       http.RoundTripper // http.DefaultTransport when nil
+	Logger     *slog.Logger
+	Propagator trace.Propagator // trace.DefaultPropagator() when nil
+	MaxBodyLog int64            // DefaultMaxBodyLog when zero
+	Redaction  *redact.Policy   // redact.Default() when nil
+}
+
+// NewTraceTransport wraps base (http.DefaultTransport when nil).
+func NewTraceTransport(l *slog.Logger, base http.RoundTripper) *TraceTransport {
+	return &TraceTransport{Base: base, Logger: l}
+}
+
+func (t *TraceTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *TraceTransport) propagator() trace.Propagator {
+	if t.Propagator != nil {
+		return t.Propagator
+	}
+	return trace.DefaultPropagator()
+}
+
+func (t *TraceTransport) maxBodyLog() int64 {
+	if t.MaxBodyLog > 0 {
+		return t.MaxBodyLog
+	}
+	return DefaultMaxBodyLog
+}
+
+func (t *TraceTransport) redaction() *redact.Policy {
+	if t.Redaction != nil {
+		return t.Redaction
+	}
+	return redact.Default()
+}
+
+func (t *TraceTransport) logger() *slog.Logger {
+	if t.Logger != nil {
+		return t.Logger
+	}
+	return slog.Default()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TraceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	logger := t.logger()
+	policy := t.redaction()
+	start := time.Now()
+
+	ctx, span := trace.Start(r.Context(), r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithStartTime(start),
+		trace.WithAttributes(
+			slog.String("http.request.method", r.Method),
+			slog.String("server.address", r.URL.Host),
+			slog.String("url.full", policy.URL(r.URL)),
+		))
+
+	// A RoundTripper must not modify the caller's request.
+	req := r.Clone(ctx)
+	t.propagator().Inject(ctx, trace.HeaderCarrier(req.Header))
+
+	bodyLevel, captureBodies := bodyLogging(logger, ctx, span.SpanContext(), false)
+	log.ContextDebug(logger, ctx, "Outgoing request", "Url", policy.URL(req.URL), "method", req.Method)
+	if captureBodies && req.Body != nil && req.Body != http.NoBody {
+		// Only the logged prefix is read ahead; the rest is streamed as usual.
+		prefix, err := io.ReadAll(io.LimitReader(req.Body, t.maxBodyLog()))
+		if err != nil {
+			req.Body.Close()
+			span.RecordError(err)
+			span.End()
+			return nil, err
+		}
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), req.Body), req.Body}
+		bodies := bodyLog{policy: policy, limit: t.maxBodyLog()}
+		args := []any{"method", req.Method, "size", len(prefix), log.LogHeadersWith(policy, req.Header)}
+		log.ContextLog(logger, ctx, bodyLevel, "Outgoing request body", append(args, bodies.attrs(req.Header, prefix)...)...)
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		log.ContextError(logger, ctx, "Outgoing request failed", "Url", policy.URL(req.URL), "method", req.Method, "elapsed", elapsed, "error", err)
+		return nil, err
+	}
+
+	span.SetAttributes(slog.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(trace.StatusError, http.StatusText(resp.StatusCode))
+	}
+	log.ContextInfo(logger, ctx, "Outgoing response", "Url", policy.URL(req.URL), "method", req.Method, "status", resp.StatusCode, "elapsed", elapsed)
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The body is the upgraded connection, an io.ReadWriteCloser callers
+		// type-assert to; it is left as is and the span ends with the
+		// handshake.
+		span.End()
+		return resp, nil
+	}
+
+	body := &tracedBody{ReadCloser: resp.Body, span: span, header: resp.Header, policy: policy}
+	if captureBodies {
+		body.logger = logger
+		body.level = bodyLevel
+		body.req = req
+		body.capture = &utils.CappedWriter{Writer: &body.buf, Remain: t.maxBodyLog()}
+		body.limit = t.maxBodyLog()
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		body.finish()
+		return resp, nil
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// tracedBody ends the client span, and logs the captured prefix of the
+// response body, once the caller has read the body to EOF or closed it.
+type tracedBody struct {
+	io.ReadCloser
+	span    *trace.Span
+	header  http.Header
+	size    int64
+	once    sync.Once
+	logger  *slog.Logger // nil unless the body is logged
+	level   slog.Level
+	req     *http.Request
+	policy  *redact.Policy
+	capture *utils.CappedWriter
+	limit   int64
+	buf     bytes.Buffer
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if b.capture != nil && n > 0 {
+		b.capture.Write(p[:n])
+	}
+	if err != nil {
+		if err != io.EOF {
+			b.span.RecordError(err)
+		}
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *tracedBody) finish() {
+	b.once.Do(func() {
+		b.span.SetAttributes(slog.Int64("http.response.body.size", b.size))
+		b.span.End()
+		if b.logger != nil {
+			ctx := b.req.Context()
+			args := []any{"Url", b.policy.URL(b.req.URL), "size", b.size, log.LogHeadersWith(b.policy, b.header)}
+			log.ContextLog(b.logger, ctx, b.level, "Outgoing response body", append(args, bodyLog{policy: b.policy, limit: b.limit}.attrs(b.header, b.buf.Bytes())...)...)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/middleware"
+	"github.com/Guadalsistema/net-utils/redact"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestTraceTransport(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	var gotHeader http.Header
+	var gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	exp := trace.NewInMemoryExporter()
+	trace.SetProcessor(trace.NewSimpleProcessor(exp))
+	t.Cleanup(func() { trace.SetProcessor(nil) })
+
+	ctx, parent := trace.Start(context.Background(), "handler")
+	client := &http.Client{Transport: middleware.NewTraceTransport(logger, nil)}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/orders", strings.NewReader(`{"id":1}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "pong" || gotBody != `{"id":1}` {
+		t.Fatalf("bodies were altered: request %q, response %q", gotBody, body)
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Errorf("caller's request must not be modified")
+	}
+
+	sc, err := trace.ParseTraceparent(gotHeader.Get("traceparent"))
+	if err != nil {
+		t.Fatalf("invalid traceparent sent: %v", err)
+	}
+	if sc.TraceID != parent.SpanContext().TraceID {
+		t.Errorf("outgoing request does not continue the trace")
+	}
+	if gotHeader.Get("X-Tx-Id") != parent.SpanContext().TraceID.String() {
+		t.Errorf("X-Tx-Id = %q", gotHeader.Get("X-Tx-Id"))
+	}
+
+	spans := exp.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one client span, got %d", len(spans))
+	}
+	client0 := spans[0]
+	if client0.Kind != trace.SpanKindClient || client0.SpanContext.SpanID != sc.SpanID || client0.Parent.SpanID != parent.SpanContext().SpanID {
+		t.Errorf("unexpected client span: %+v", client0)
+	}
+
+	logs := logBuf.String()
+	for _, want := range []string{"Outgoing request", `{\"id\":1}`, "status=201", "elapsed=", "body=pong", "trace=" + sc.TraceID.String()} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("missing %q in logs:\n%s", want, logs)
+		}
+	}
+}
+
+func TestTraceTransportGzipResponse(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("compressed pong"))
+		gz.Close()
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: middleware.NewTraceTransport(logger, nil)}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip") // disables transparent decompression
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if !strings.Contains(logBuf.String(), `body="compressed pong"`) {
+		t.Errorf("expected decompressed body in logs:\n%s", logBuf.String())
+	}
+}
+
+func TestTraceTransportUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString(line)
+		rw.Flush()
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: middleware.NewTraceTransport(slog.New(slog.DiscardHandler), nil)}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("upgraded body %T is not writable", resp.Body)
+	}
+	io.WriteString(conn, "hello\n")
+	got := make([]byte, len("hello\n"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello\n" {
+		t.Fatalf("echo = %q, %v", got, err)
+	}
+}
+
+func TestTraceTransportRedaction(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	server := httptest.NewServer(http.HandlerFunc(ok))
+	defer server.Close()
+
+	transport := middleware.NewTraceTransport(logger, nil)
+	transport.Redaction = &redact.Policy{QueryParams: []string{"session"}}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(server.URL + "/?session=s3cret&token=visible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	logs := logBuf.String()
+	if strings.Contains(logs, "s3cret") || !strings.Contains(logs, "token=visible") {
+		t.Fatalf("transport policy not applied:\n%s", logs)
+	}
+}
@@ -2,10 +2,12 @@ package migrate
 
 import (
 	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite" // registers the sqlite driver (pure go)
 	_ "github.com/golang-migrate/migrate/v4/source/file"     // registers the file source
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
 // newMigrate constructs a *migrate.Migrate for the given SQLite URL and migrations dir.
@@ -31,6 +33,27 @@ func Up(databaseURL, migrationsDir string) error {
 	return nil
 }
 
+// UpFS applies all up-migrations found in dir of fsys, typically an
+// embed.FS shipped by a package that owns tables. ErrNoChange is treated as
+// success. Such packages should keep their own migrations table, through
+// the x-migrations-table parameter of databaseURL, so their versions do not
+// mix with the application's.
+func UpFS(databaseURL string, fsys fs.FS, dir string) error {
+	src, err := iofs.New(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("reading migrations: %w", err)
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", src, databaseURL)
+	if err != nil {
+		return fmt.Errorf("initializing migrate: %w", err)
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("applying up migrations: %w", err)
+	}
+	return nil
+}
+
 // Down rolls back the most recent migration. ErrNoChange is treated as success.
 func Down(databaseURL, migrationsDir string) error {
 	m, err := newMigrate(databaseURL, migrationsDir)
@@ -45,11 +68,14 @@ func Down(databaseURL, migrationsDir string) error {
 
 // Version returns the current migration version and dirty state.
 // If no migration has been applied, version==0 and dirty==false.
+// It releases its database connection, so it can be polled, e.g. by health
+// checks.
 func Version(databaseURL, migrationsDir string) (version uint, dirty bool, err error) {
 	m, err := newMigrate(databaseURL, migrationsDir)
 	if err != nil {
 		return 0, false, err
 	}
+	defer m.Close()
 	v, d, err := m.Version()
 	if err == migrate.ErrNilVersion {
 		return 0, false, nil
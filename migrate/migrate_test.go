@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/Guadalsistema/net-utils/migrate"
 )
@@ -135,3 +136,33 @@ func TestNewMigrateRelativePath(t *testing.T) {
 		t.Fatalf("expected table 'seller' to be dropped after Down with relative path")
 	}
 }
+
+// TestUpFS applies migrations from an fs.FS into their own migrations table.
+func TestUpFS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	databaseURL := "sqlite://" + path + "?x-migrations-table=buyer_migrations"
+	fsys := fstest.MapFS{
+		"sql/1_create_buyer_table.up.sql":   {Data: []byte("CREATE TABLE buyer (id INTEGER PRIMARY KEY);")},
+		"sql/1_create_buyer_table.down.sql": {Data: []byte("DROP TABLE buyer;")},
+	}
+
+	for range 2 { // the second run has nothing to do
+		if err := migrate.UpFS(databaseURL, fsys, "sql"); err != nil {
+			t.Fatalf("UpFS() failed: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow("SELECT version FROM buyer_migrations").Scan(&version); err != nil || version != 1 {
+		t.Fatalf("buyer_migrations version = %d, %v", version, err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='buyer'").Scan(&name); err != nil {
+		t.Fatalf("expected table 'buyer' to exist after UpFS: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package problem implements RFC 9457 problem details for HTTP APIs.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Guadalsistema/net-utils/trace"
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// ContentType is the media type of a problem details document.
+const ContentType = "application/problem+json"
+
+// TraceIdMember is the extension member carrying the transaction ID.
+const TraceIdMember = "traceId"
+
+// DefaultType is the problem type used when none is given: the problem has
+// no semantics beyond its HTTP status code.
+const DefaultType = "about:blank"
+
+// maxProblemSize bounds the body read by Parse.
+const maxProblemSize = 1 << 16
+
+// Problem is an RFC 9457 problem details object. It implements error, so a
+// handler can return one and have it written unchanged.
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions holds the additional members, written after the standard
+	// ones in insertion order.
+	Extensions utils.OrderedObject
+}
+
+// New returns a problem of DefaultType with the standard title of status.
+func New(status int, detail string) *Problem {
+	return &Problem{Type: DefaultType, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// With sets the extension member key, replacing any previous value, and
+// returns p for chaining. Keys of standard members are ignored.
+func (p *Problem) With(key string, value any) *Problem {
+	if isStandard(key) {
+		return p
+	}
+	for i, m := range p.Extensions {
+		if m.Key == key {
+			p.Extensions[i].Value = value
+			return p
+		}
+	}
+	p.Extensions = append(p.Extensions, utils.ObjectMember{Key: key, Value: value})
+	return p
+}
+
+// Extension returns the value of an extension member.
+func (p *Problem) Extension(key string) (any, bool) {
+	return p.Extensions.Get(key)
+}
+
+// TraceId returns the transaction ID carried by the problem, if any.
+func (p *Problem) TraceId() string {
+	id, _ := p.Extensions.Get(TraceIdMember)
+	s, _ := id.(string)
+	return s
+}
+
+func (p *Problem) Error() string {
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.Status)
+	}
+	if p.Detail != "" {
+		return fmt.Sprintf("%d %s: %s", p.Status, title, p.Detail)
+	}
+	return fmt.Sprintf("%d %s", p.Status, title)
+}
+
+// Clone returns a copy of p that can be modified independently.
+func (p *Problem) Clone() *Problem {
+	c := *p
+	c.Extensions = append(utils.OrderedObject(nil), p.Extensions...)
+	return &c
+}
+
+func isStandard(key string) bool {
+	switch key {
+	case "type", "title", "status", "detail", "instance":
+		return true
+	}
+	return false
+}
+
+// MarshalJSON writes the standard members first, then the extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	typ := p.Type
+	if typ == "" {
+		typ = DefaultType
+	}
+	obj := utils.OrderedObject{{Key: "type", Value: typ}}
+	if p.Title != "" {
+		obj = append(obj, utils.ObjectMember{Key: "title", Value: p.Title})
+	}
+	if p.Status != 0 {
+		obj = append(obj, utils.ObjectMember{Key: "status", Value: p.Status})
+	}
+	if p.Detail != "" {
+		obj = append(obj, utils.ObjectMember{Key: "detail", Value: p.Detail})
+	}
+	if p.Instance != "" {
+		obj = append(obj, utils.ObjectMember{Key: "instance", Value: p.Instance})
+	}
+	for _, m := range p.Extensions {
+		if !isStandard(m.Key) {
+			obj = append(obj, m)
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// UnmarshalJSON reads a problem document. As RFC 9457 requires, standard
+// members of the wrong type are ignored rather than rejected.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var obj utils.OrderedObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for _, m := range obj {
+		switch m.Key {
+		case "type":
+			p.Type, _ = m.Value.(string)
+		case "title":
+			p.Title, _ = m.Value.(string)
+		case "status":
+			if status, ok := m.Value.(int64); ok {
+				p.Status = int(status)
+			}
+		case "detail":
+			p.Detail, _ = m.Value.(string)
+		case "instance":
+			p.Instance, _ = m.Value.(string)
+		default:
+			p.Extensions = append(p.Extensions, m)
+		}
+	}
+	if p.Type == "" {
+		p.Type = DefaultType
+	}
+	return nil
+}
+
+// Write sends p as the response. The transaction ID of the request, from
+// trace.TraceIdFrom, is added as the traceId member and echoed in the
+// X-Tx-Id header. A zero Status is sent as 500.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p = p.Clone()
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Title == "" && (p.Type == "" || p.Type == DefaultType) {
+		p.Title = http.StatusText(p.Status)
+	}
+	if r != nil {
+		if txId, ok := trace.TraceIdFrom(r.Context()); ok {
+			if p.TraceId() == "" {
+				p.With(TraceIdMember, txId)
+			}
+			if w.Header().Get(trace.TxIdHeader) == "" {
+				w.Header().Set(trace.TxIdHeader, txId)
+			}
+		}
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(data)
+}
+
+// Error writes a problem for status with the given detail, the problem
+// counterpart of http.Error.
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	Write(w, r, New(status, detail))
+}
+
+// ErrNotProblem is returned by Parse for responses that are not problem
+// details documents.
+var ErrNotProblem = errors.New("problem: response is not application/problem+json")
+
+// IsProblem reports whether resp carries a problem details document.
+func IsProblem(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == ContentType
+}
+
+// Parse reads the problem details document of a client response. It consumes
+// the body but does not close it. When the document has no status, the status
+// code of the response is used.
+func Parse(resp *http.Response) (*Problem, error) {
+	if !IsProblem(resp) {
+		return nil, ErrNotProblem
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProblemSize))
+	if err != nil {
+		return nil, err
+	}
+	var p Problem
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("problem: %w", err)
+	}
+	if p.Status == 0 {
+		p.Status = resp.StatusCode
+	}
+	return &p, nil
+}
@@ -0,0 +1,126 @@
+package problem_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/problem"
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestProblem_MarshalOrder(t *testing.T) {
+	p := &problem.Problem{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   http.StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+	}
+	p.With("balance", 30).With("accounts", []string{"/account/12345", "/account/67890"}).With("status", 1)
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,` +
+		`"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc",` +
+		`"balance":30,"accounts":["/account/12345","/account/67890"]}`
+	if string(data) != want {
+		t.Fatalf("got  %s\nwant %s", data, want)
+	}
+}
+
+func TestProblem_UnmarshalIgnoresWrongTypes(t *testing.T) {
+	var p problem.Problem
+	if err := json.Unmarshal([]byte(`{"status":"404","title":7,"detail":"gone","code":"E42"}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != problem.DefaultType || p.Status != 0 || p.Title != "" || p.Detail != "gone" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+	if code, _ := p.Extension("code"); code != "E42" {
+		t.Fatalf("code = %v", code)
+	}
+}
+
+func TestWrite_AddsTraceId(t *testing.T) {
+	ctx := trace.WithTraceId(context.Background(), "tx-123")
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	problem.Error(rr, req, http.StatusNotFound, "no such item")
+
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("status %d, Content-Type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr.Header().Get(trace.TxIdHeader) != "tx-123" {
+		t.Fatalf("X-Tx-Id = %q", rr.Header().Get(trace.TxIdHeader))
+	}
+	want := `{"type":"about:blank","title":"Not Found","status":404,"detail":"no such item","traceId":"tx-123"}`
+	if rr.Body.String() != want {
+		t.Fatalf("got  %s\nwant %s", rr.Body.String(), want)
+	}
+}
+
+func TestWrite_DoesNotModifyProblem(t *testing.T) {
+	p := &problem.Problem{}
+	ctx := trace.WithTraceId(context.Background(), "tx-1")
+	problem.Write(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), p)
+	if p.Status != 0 || len(p.Extensions) != 0 {
+		t.Fatalf("problem modified: %+v", p)
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := trace.WithTraceId(r.Context(), "tx-9")
+		problem.Write(w, r.WithContext(ctx), problem.New(http.StatusConflict, "version mismatch").With("expected", 3))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	p, err := problem.Parse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != http.StatusConflict || p.Detail != "version mismatch" || p.TraceId() != "tx-9" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+	if v, _ := p.Extension("expected"); v != int64(3) {
+		t.Fatalf("expected = %#v", v)
+	}
+	if !strings.Contains(p.Error(), "409 Conflict: version mismatch") {
+		t.Fatalf("Error() = %q", p.Error())
+	}
+}
+
+func TestParse_NotProblem(t *testing.T) {
+	resp := &http.Response{StatusCode: 500, Header: http.Header{"Content-Type": {"text/plain"}}, Body: http.NoBody}
+	if _, err := problem.Parse(resp); err != problem.ErrNotProblem {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParse_DefaultsStatusFromResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTeapot,
+		Header:     http.Header{"Content-Type": {"application/problem+json; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(`{"title":"Short and stout"}`)),
+	}
+	p, err := problem.Parse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != http.StatusTeapot || p.Title != "Short and stout" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+}
@@ -0,0 +1,85 @@
+package problem
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Mapper turns an error into a problem. It returns false for errors it does
+// not handle.
+type Mapper func(err error) (*Problem, bool)
+
+// Registry maps Go errors, wrapped or not, to problems. Mappings are tried in
+// registration order; the first match wins.
+type Registry struct {
+	mu      sync.RWMutex
+	mappers []Mapper
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register maps every error matching target with errors.Is to a copy of p.
+func (reg *Registry) Register(target error, p *Problem) {
+	reg.RegisterFunc(func(err error) (*Problem, bool) {
+		if errors.Is(err, target) {
+			return p.Clone(), true
+		}
+		return nil, false
+	})
+}
+
+// RegisterFunc adds a mapper, typically one using errors.As for error types.
+func (reg *Registry) RegisterFunc(m Mapper) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.mappers = append(reg.mappers, m)
+}
+
+// From returns the problem describing err. A *Problem in the chain of err is
+// returned as is; unmapped errors become a bare 500, so internal error
+// messages never reach the client.
+func (reg *Registry) From(err error) *Problem {
+	if err == nil {
+		return nil
+	}
+	reg.mu.RLock()
+	mappers := reg.mappers
+	reg.mu.RUnlock()
+	for _, m := range mappers {
+		if p, ok := m(err); ok && p != nil {
+			return p
+		}
+	}
+	var p *Problem
+	if errors.As(err, &p) {
+		return p.Clone()
+	}
+	return New(http.StatusInternalServerError, "")
+}
+
+// WriteError writes the problem describing err.
+func (reg *Registry) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Write(w, r, reg.From(err))
+}
+
+// DefaultRegistry is used by the package level Register, From and
+// WriteError functions.
+var DefaultRegistry = NewRegistry()
+
+// Register maps target to p in DefaultRegistry.
+func Register(target error, p *Problem) { DefaultRegistry.Register(target, p) }
+
+// RegisterFunc adds a mapper to DefaultRegistry.
+func RegisterFunc(m Mapper) { DefaultRegistry.RegisterFunc(m) }
+
+// From returns the problem describing err according to DefaultRegistry.
+func From(err error) *Problem { return DefaultRegistry.From(err) }
+
+// WriteError writes the problem describing err according to DefaultRegistry.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	DefaultRegistry.WriteError(w, r, err)
+}
@@ -0,0 +1,71 @@
+package problem_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/problem"
+)
+
+var errNotFound = errors.New("not found")
+
+type validationError struct{ Field string }
+
+func (e *validationError) Error() string { return "invalid " + e.Field }
+
+func TestRegistry_From(t *testing.T) {
+	reg := problem.NewRegistry()
+	reg.Register(errNotFound, problem.New(http.StatusNotFound, "resource not found"))
+	reg.RegisterFunc(func(err error) (*problem.Problem, bool) {
+		var ve *validationError
+		if !errors.As(err, &ve) {
+			return nil, false
+		}
+		return problem.New(http.StatusUnprocessableEntity, ve.Error()).With("field", ve.Field), true
+	})
+
+	cases := []struct {
+		err    error
+		status int
+		detail string
+	}{
+		{fmt.Errorf("loading item 7: %w", errNotFound), http.StatusNotFound, "resource not found"},
+		{fmt.Errorf("decode: %w", &validationError{Field: "email"}), http.StatusUnprocessableEntity, "invalid email"},
+		{fmt.Errorf("wrapped: %w", problem.New(http.StatusGone, "moved away")), http.StatusGone, "moved away"},
+		{errors.New("pq: connection refused at 10.0.0.3"), http.StatusInternalServerError, ""},
+	}
+	for _, c := range cases {
+		p := reg.From(c.err)
+		if p.Status != c.status || p.Detail != c.detail {
+			t.Errorf("%v: got %d %q, want %d %q", c.err, p.Status, p.Detail, c.status, c.detail)
+		}
+	}
+	if reg.From(nil) != nil {
+		t.Error("nil error should map to no problem")
+	}
+}
+
+func TestRegistry_ReturnsCopies(t *testing.T) {
+	reg := problem.NewRegistry()
+	reg.Register(context.DeadlineExceeded, problem.New(http.StatusGatewayTimeout, ""))
+
+	reg.From(context.DeadlineExceeded).With("attempt", 1)
+	if _, ok := reg.From(context.DeadlineExceeded).Extension("attempt"); ok {
+		t.Fatal("registered problem was modified")
+	}
+}
+
+func TestWriteError_DefaultRegistry(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	problem.Register(errQuota, problem.New(http.StatusTooManyRequests, "slow down"))
+
+	rr := httptest.NewRecorder()
+	problem.WriteError(rr, httptest.NewRequest(http.MethodGet, "/", nil), fmt.Errorf("send: %w", errQuota))
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("status %d, Content-Type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,160 @@
+// Package ratelimit implements token bucket and sliding window rate limits
+// over pluggable state stores.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Result is the outcome of a rate limit check.
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests allowed per period.
+	Limit int
+	// Remaining is the number of requests still allowed right now.
+	Remaining int
+	// Reset is the time until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is the time to wait before the next request is allowed. It
+	// is zero when the request was allowed.
+	RetryAfter time.Duration
+	// Period is the window the limit applies to.
+	Period time.Duration
+}
+
+// Limiter decides whether a request identified by key may proceed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Token bucket                                                              */
+/* -------------------------------------------------------------------------- */
+
+// TokenBucket refills Limit tokens every Period into a bucket holding at most
+// Burst tokens; every request takes one token. It allows short bursts while
+// bounding the average rate.
+type TokenBucket struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+	Store  Store
+	// Clock returns the current time; time.Now when nil.
+	Clock func() time.Time
+}
+
+// NewTokenBucket returns a token bucket of limit requests per period with
+// bursts of up to burst requests (limit when burst is not positive). A nil
+// store means a new MemoryStore. It panics when limit or period is not
+// positive, as the refill rate would be undefined.
+func NewTokenBucket(limit int, period time.Duration, burst int, store Store) *TokenBucket {
+	if limit <= 0 || period <= 0 {
+		panic(fmt.Sprintf("ratelimit: invalid token bucket rate %d per %v", limit, period))
+	}
+	if burst <= 0 {
+		burst = limit
+	}
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+	return &TokenBucket{Limit: limit, Period: period, Burst: burst, Store: store}
+}
+
+func (tb *TokenBucket) Allow(ctx context.Context, key string) (Result, error) {
+	now := clock(tb.Clock)
+	rate := float64(tb.Limit) / tb.Period.Seconds() // tokens per second
+	burst := float64(tb.Burst)
+	res := Result{Limit: tb.Limit, Period: tb.Period}
+	ttl := seconds(burst / rate) // a full bucket is the same as no state
+	err := tb.Store.Update(ctx, key, ttl, func(s *State) {
+		tokens := burst
+		if !s.Stamp.IsZero() {
+			tokens = min(burst, s.Value+now.Sub(s.Stamp).Seconds()*rate)
+		}
+		if tokens >= 1 {
+			tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = seconds((1 - tokens) / rate)
+		}
+		s.Value, s.Stamp = tokens, now
+		res.Remaining = int(math.Floor(tokens))
+		res.Reset = seconds((burst - tokens) / rate)
+	})
+	return res, err
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Sliding window                                                            */
+/* -------------------------------------------------------------------------- */
+
+// SlidingWindow allows Limit requests in any Window. It keeps the counts of
+// the current and previous fixed windows and weights the previous one by how
+// much of it still overlaps the sliding window, which smooths the bursts a
+// fixed window allows at its edges.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+	Store  Store
+	// Clock returns the current time; time.Now when nil.
+	Clock func() time.Time
+}
+
+// NewSlidingWindow returns a sliding window of limit requests per window. A
+// nil store means a new MemoryStore. It panics when limit or window is not
+// positive, as no request could ever be allowed.
+func NewSlidingWindow(limit int, window time.Duration, store Store) *SlidingWindow {
+	if limit <= 0 || window <= 0 {
+		panic(fmt.Sprintf("ratelimit: invalid sliding window of %d per %v", limit, window))
+	}
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+	return &SlidingWindow{Limit: limit, Window: window, Store: store}
+}
+
+func (sw *SlidingWindow) Allow(ctx context.Context, key string) (Result, error) {
+	now := clock(sw.Clock)
+	limit := float64(sw.Limit)
+	start := now.Truncate(sw.Window)
+	res := Result{Limit: sw.Limit, Period: sw.Window}
+	err := sw.Store.Update(ctx, key, 2*sw.Window, func(s *State) {
+		if !s.Stamp.Equal(start) {
+			prev := 0.0
+			if s.Stamp.Equal(start.Add(-sw.Window)) {
+				prev = s.Value
+			}
+			s.Value, s.Prev, s.Stamp = 0, prev, start
+		}
+		elapsed := float64(now.Sub(start)) / float64(sw.Window)
+		estimate := s.Prev*(1-elapsed) + s.Value
+		res.Reset = start.Add(sw.Window).Sub(now)
+		if estimate+1 <= limit {
+			s.Value++
+			res.Allowed = true
+			res.Remaining = int(math.Floor(limit - estimate - 1))
+			return
+		}
+		// wait until enough of the previous window has slid out
+		res.RetryAfter = res.Reset
+		if free := limit - s.Value - 1; free >= 0 && s.Prev > 0 {
+			at := 1 - free/s.Prev
+			res.RetryAfter = max(time.Duration(at*float64(sw.Window))-now.Sub(start), time.Millisecond)
+		}
+	})
+	return res, err
+}
+
+func clock(c func() time.Time) time.Time {
+	if c != nil {
+		return c()
+	}
+	return time.Now()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func memoryStore(c *fakeClock) *ratelimit.MemoryStore {
+	s := ratelimit.NewMemoryStore(0)
+	s.Clock = c.Now
+	return s
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := newClock()
+	tb := ratelimit.NewTokenBucket(10, time.Second, 3, memoryStore(c))
+	tb.Clock = c.Now
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		res, err := tb.Allow(ctx, "a")
+		if err != nil || !res.Allowed || res.Remaining != i {
+			t.Fatalf("burst request: %+v, %v", res, err)
+		}
+	}
+	res, _ := tb.Allow(ctx, "a")
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("over burst: %+v", res)
+	}
+	if res, _ := tb.Allow(ctx, "b"); !res.Allowed {
+		t.Fatal("keys are not independent")
+	}
+
+	c.Advance(100 * time.Millisecond) // one token
+	if res, _ := tb.Allow(ctx, "a"); !res.Allowed {
+		t.Fatalf("refilled token denied: %+v", res)
+	}
+	if res, _ := tb.Allow(ctx, "a"); res.Allowed {
+		t.Fatal("only one token should have been refilled")
+	}
+
+	c.Advance(time.Hour)
+	res, _ = tb.Allow(ctx, "a")
+	if !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("bucket not capped at burst: %+v", res)
+	}
+}
+
+func TestNewTokenBucket_InvalidRate(t *testing.T) {
+	for _, tc := range []struct {
+		limit  int
+		period time.Duration
+	}{{0, time.Second}, {-1, time.Second}, {1, 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewTokenBucket(%d, %v) did not panic", tc.limit, tc.period)
+				}
+			}()
+			ratelimit.NewTokenBucket(tc.limit, tc.period, 0, nil)
+		}()
+	}
+}
+
+func TestNewSlidingWindow_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		limit  int
+		window time.Duration
+	}{{0, time.Minute}, {-1, time.Minute}, {1, 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewSlidingWindow(%d, %v) did not panic", tc.limit, tc.window)
+				}
+			}()
+			ratelimit.NewSlidingWindow(tc.limit, tc.window, nil)
+		}()
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	c := newClock()
+	sw := ratelimit.NewSlidingWindow(4, time.Minute, memoryStore(c))
+	sw.Clock = c.Now
+	ctx := context.Background()
+
+	for i := 3; i >= 0; i-- {
+		res, _ := sw.Allow(ctx, "a")
+		if !res.Allowed || res.Remaining != i {
+			t.Fatalf("request within limit: %+v", res)
+		}
+	}
+	res, _ := sw.Allow(ctx, "a")
+	if res.Allowed || res.RetryAfter != time.Minute || res.Reset != time.Minute {
+		t.Fatalf("over limit: %+v", res)
+	}
+
+	// 15s into the next window, 3/4 of the previous 4 requests still count
+	c.Advance(75 * time.Second)
+	res, _ = sw.Allow(ctx, "a")
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("weighted window: %+v", res)
+	}
+	res, _ = sw.Allow(ctx, "a")
+	if res.Allowed || res.RetryAfter != 15*time.Second {
+		t.Fatalf("expected to wait until another previous request slides out: %+v", res)
+	}
+
+	c.Advance(15 * time.Second)
+	if res, _ := sw.Allow(ctx, "a"); !res.Allowed {
+		t.Fatalf("request after RetryAfter denied: %+v", res)
+	}
+
+	c.Advance(3 * time.Minute) // both windows are gone
+	if res, _ := sw.Allow(ctx, "a"); !res.Allowed || res.Remaining != 3 {
+		t.Fatalf("stale windows kept: %+v", res)
+	}
+}
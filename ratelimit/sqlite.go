@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the sqlite driver (pure go)
+
+	"github.com/Guadalsistema/net-utils/database"
+)
+
+// DefaultSQLiteTable is the table used by SQLiteStore.
+const DefaultSQLiteTable = "rate_limits"
+
+// SQLiteStore keeps limiter state in a SQLite database, so limits survive
+// restarts and are shared by every process using the same file. Updates run
+// in IMMEDIATE transactions, which SQLite serialises across processes.
+type SQLiteStore struct {
+	db    *sql.DB
+	table string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+	// Clock returns the current time; time.Now when nil.
+	Clock func() time.Time
+}
+
+// NewSQLiteStore uses db, creating the table when it does not exist. An
+// empty table name means DefaultSQLiteTable.
+func NewSQLiteStore(ctx context.Context, db *sql.DB, table string) (*SQLiteStore, error) {
+	if table == "" {
+		table = DefaultSQLiteTable
+	}
+	if strings.ContainsFunc(table, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		return nil, fmt.Errorf("ratelimit: invalid table name %q", table)
+	}
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+		key     TEXT PRIMARY KEY,
+		value   REAL NOT NULL,
+		prev    REAL NOT NULL,
+		stamp   INTEGER NOT NULL,
+		expires INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: creating table: %w", err)
+	}
+	return &SQLiteStore{db: db, table: table}, nil
+}
+
+// OpenSQLiteStore opens the SQLite database of dsn, as accepted by
+// database.ParseDSN, with the pragmas of database.SharedSQLiteDSN so several
+// processes can share it. In-memory databases are kept on a single
+// connection, so the state is not spread over several empty databases.
+func OpenSQLiteStore(ctx context.Context, dsn string) (*SQLiteStore, error) {
+	driver, path := database.ParseDSN(dsn)
+	if driver != "sqlite" {
+		return nil, fmt.Errorf("ratelimit: unsupported driver %q", driver)
+	}
+	path = database.SharedSQLiteDSN(path)
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: opening database: %w", err)
+	}
+	if database.IsSQLiteMemory(path) {
+		// every connection to :memory: opens a database of its own
+		db.SetMaxOpenConns(1)
+	}
+	s, err := NewSQLiteStore(ctx, db, "")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// DB returns the underlying database.
+func (s *SQLiteStore) DB() *sql.DB { return s.db }
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error { return s.db.Close() }
+
+func (s *SQLiteStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(*State)) (err error) {
+	now := clock(s.Clock)
+	s.sweep(ctx, now)
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ratelimit: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("ratelimit: begin: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		}
+	}()
+
+	var state State
+	var stamp, expires int64
+	err = conn.QueryRowContext(ctx, `SELECT value, prev, stamp, expires FROM `+s.table+` WHERE key = ?`, key).
+		Scan(&state.Value, &state.Prev, &stamp, &expires)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return fmt.Errorf("ratelimit: reading state: %w", err)
+	case now.UnixNano() < expires:
+		state.Stamp = time.Unix(0, stamp)
+	default:
+		state = State{} // expired
+	}
+
+	fn(&state)
+
+	var stored int64
+	if !state.Stamp.IsZero() {
+		stored = state.Stamp.UnixNano()
+	}
+	_, err = conn.ExecContext(ctx, `INSERT INTO `+s.table+` (key, value, prev, stamp, expires) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, prev = excluded.prev, stamp = excluded.stamp, expires = excluded.expires`,
+		key, state.Value, state.Prev, stored, now.Add(ttl).UnixNano())
+	if err != nil {
+		return fmt.Errorf("ratelimit: saving state: %w", err)
+	}
+	if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return fmt.Errorf("ratelimit: commit: %w", err)
+	}
+	return nil
+}
+
+// sweep deletes expired rows at most once per sweepInterval.
+func (s *SQLiteStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if due {
+		s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires <= ?`, now.UnixNano())
+	}
+}
@@ -0,0 +1,137 @@
+package ratelimit_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+func TestSQLiteStore_SharedAndPersistent(t *testing.T) {
+	ctx := context.Background()
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "limits.db")
+	c := newClock()
+
+	a, err := ratelimit.OpenSQLiteStore(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ratelimit.OpenSQLiteStore(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a.Clock, b.Clock = c.Now, c.Now
+
+	limitA := ratelimit.NewSlidingWindow(5, time.Minute, a)
+	limitB := ratelimit.NewSlidingWindow(5, time.Minute, b)
+	limitA.Clock, limitB.Clock = c.Now, c.Now
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := limitA
+			if i%2 == 1 {
+				l = limitB
+			}
+			res, err := l.Allow(ctx, "client")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Fatalf("allowed %d requests across stores, want 5", allowed)
+	}
+
+	// state survives reopening the database
+	a.Close()
+	reopened, err := ratelimit.OpenSQLiteStore(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	reopened.Clock = c.Now
+	limit := ratelimit.NewSlidingWindow(5, time.Minute, reopened)
+	limit.Clock = c.Now
+	if res, _ := limit.Allow(ctx, "client"); res.Allowed {
+		t.Fatal("limit reset by restart")
+	}
+}
+
+func TestSQLiteStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	s, err := ratelimit.OpenSQLiteStore(ctx, filepath.Join(t.TempDir(), "limits.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := newClock()
+	s.Clock = c.Now
+
+	s.Update(ctx, "k", time.Second, func(st *ratelimit.State) { st.Value, st.Stamp = 3, c.now })
+	s.Update(ctx, "k", time.Second, func(st *ratelimit.State) {
+		if st.Value != 3 || !st.Stamp.Equal(c.now) {
+			t.Fatalf("state not kept: %+v", st)
+		}
+	})
+	c.Advance(2 * time.Second)
+	s.Update(ctx, "k", time.Second, func(st *ratelimit.State) {
+		if st.Value != 0 {
+			t.Fatalf("expired state returned: %+v", st)
+		}
+	})
+}
+
+func TestOpenSQLiteStore_Memory(t *testing.T) {
+	ctx := context.Background()
+	s, err := ratelimit.OpenSQLiteStore(ctx, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := s.DB().Stats().MaxOpenConnections; n != 1 {
+		t.Fatalf("in-memory database allows %d connections, want 1", n)
+	}
+	limit := ratelimit.NewSlidingWindow(3, time.Minute, s)
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limit.Allow(ctx, "client"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if res, _ := limit.Allow(ctx, "client"); res.Allowed {
+		t.Fatal("state spread over several in-memory databases")
+	}
+}
+
+func TestNewSQLiteStore_InvalidTable(t *testing.T) {
+	s, err := ratelimit.OpenSQLiteStore(context.Background(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := ratelimit.NewSQLiteStore(context.Background(), s.DB(), "x; DROP TABLE y"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
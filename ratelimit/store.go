@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// State is the per key state of a limiter. Its meaning depends on the
+// algorithm: tokens and last refill for a token bucket, current count,
+// previous count and window start for a sliding window.
+type State struct {
+	Value float64
+	Prev  float64
+	Stamp time.Time
+}
+
+// Store keeps limiter state.
+type Store interface {
+	// Update calls fn with the state of key, the zero State when there is
+	// none or it expired, and saves the modified state for ttl. Concurrent
+	// updates of the same key must not interleave.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(*State)) error
+}
+
+// DefaultMaxKeys bounds the keys kept by a MemoryStore by default.
+const DefaultMaxKeys = 100_000
+
+// sweepInterval is how often stores drop expired keys.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// MemoryStore keeps limiter state in process memory. Expired keys are swept
+// periodically and, once MaxKeys is reached, the tenth of the keys closest
+// to expiry is evicted at once, so a full store does not pay for an
+// eviction on every new key.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	maxKeys   int
+	lastSweep time.Time
+	// Clock returns the current time; time.Now when nil.
+	Clock func() time.Time
+}
+
+// NewMemoryStore returns a store holding at most maxKeys keys,
+// DefaultMaxKeys when maxKeys is not positive.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &MemoryStore{entries: make(map[string]*memoryEntry), maxKeys: maxKeys}
+}
+
+func (m *MemoryStore) Update(_ context.Context, key string, ttl time.Duration, fn func(*State)) error {
+	now := clock(m.Clock)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if ok && !now.Before(e.expires) {
+		e.state = State{}
+	}
+	if !ok {
+		if now.Sub(m.lastSweep) >= sweepInterval || len(m.entries) >= m.maxKeys {
+			m.sweep(now)
+		}
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+	return nil
+}
+
+// Len returns the number of keys held, expired or not.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// sweep drops expired keys, then, when the store is still full, evicts the
+// keys closest to expiry down to nine tenths of maxKeys.
+func (m *MemoryStore) sweep(now time.Time) {
+	m.lastSweep = now
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	if len(m.entries) < m.maxKeys {
+		return
+	}
+	over := len(m.entries) - m.maxKeys + max(1, m.maxKeys/10)
+	keys := make([]string, 0, len(m.entries))
+	for k := range m.entries {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return m.entries[a].expires.Compare(m.entries[b].expires)
+	})
+	for _, k := range keys[:over] {
+		delete(m.entries, k)
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/ratelimit"
+)
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	c := newClock()
+	s := memoryStore(c)
+	ctx := context.Background()
+
+	s.Update(ctx, "k", time.Second, func(st *ratelimit.State) { st.Value = 5 })
+	c.Advance(2 * time.Second)
+	s.Update(ctx, "k", time.Second, func(st *ratelimit.State) {
+		if st.Value != 0 {
+			t.Fatalf("expired state returned: %+v", st)
+		}
+	})
+}
+
+func TestMemoryStore_Eviction(t *testing.T) {
+	c := newClock()
+	s := ratelimit.NewMemoryStore(3)
+	s.Clock = c.Now
+	ctx := context.Background()
+
+	for i := range 3 {
+		s.Update(ctx, fmt.Sprint(i), time.Duration(i+1)*time.Minute, func(*ratelimit.State) {})
+	}
+	s.Update(ctx, "new", time.Minute, func(*ratelimit.State) {})
+	if s.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", s.Len())
+	}
+	// key "0" expired first so it was evicted
+	s.Update(ctx, "0", time.Minute, func(st *ratelimit.State) {
+		if !st.Stamp.IsZero() || st.Value != 0 {
+			t.Fatal("evicted key kept its state")
+		}
+	})
+
+	c.Advance(time.Hour)
+	s.Update(ctx, "late", time.Minute, func(*ratelimit.State) {})
+	if s.Len() != 1 {
+		t.Fatalf("expired keys not swept, Len = %d", s.Len())
+	}
+}
+
+func TestMemoryStore_EvictsInBatches(t *testing.T) {
+	c := newClock()
+	s := ratelimit.NewMemoryStore(100)
+	s.Clock = c.Now
+	ctx := context.Background()
+
+	for i := range 100 {
+		s.Update(ctx, fmt.Sprint(i), time.Duration(i+1)*time.Minute, func(st *ratelimit.State) { st.Value = 1 })
+	}
+	s.Update(ctx, "new", time.Minute, func(*ratelimit.State) {})
+	if s.Len() != 91 {
+		t.Fatalf("Len = %d, want 91 after evicting a tenth of the keys", s.Len())
+	}
+	// the keys closest to expiry went first
+	s.Update(ctx, "9", time.Minute, func(st *ratelimit.State) {
+		if st.Value != 0 {
+			t.Fatal("key closest to expiry kept")
+		}
+	})
+	s.Update(ctx, "10", time.Minute, func(st *ratelimit.State) {
+		if st.Value != 1 {
+			t.Fatal("key evicted out of order")
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// DefaultMask replaces redacted values.
+const DefaultMask = "[REDACTED]"
+
+// Pattern masks every match of Regexp in non-JSON bodies. When Valid is set,
+// only matches for which it returns true are masked.
+type Pattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+	Valid  func(match string) bool
+}
+
+// Policy describes what is hidden from logged HTTP traffic. Name lists are
+// matched case-insensitively.
+type Policy struct {
+	// DenyHeaders are always masked.
+	DenyHeaders []string
+	// AllowHeaders, when not empty, is the only set of headers logged
+	// verbatim; every other header is masked.
+	AllowHeaders []string
+	// QueryParams are masked in logged URLs.
+	QueryParams []string
+	// BodyFields are masked at any depth of JSON bodies and in form bodies.
+	BodyFields []string
+	// JSONPointers (RFC 6901) are masked in JSON bodies. A "*" segment
+	// matches any member or array element.
+	JSONPointers []string
+	// Patterns are masked in bodies that are not valid JSON.
+	Patterns []Pattern
+	// Mask replaces redacted values; DefaultMask when empty.
+	Mask string
+
+	fieldsRegexp atomic.Pointer[fieldsRegexp] // compiled from BodyFields on first use
+}
+
+// fieldsRegexp is the regexp compiled for a list of body fields.
+type fieldsRegexp struct {
+	fields []string
+	re     *regexp.Regexp
+}
+
+// Built-in patterns for DefaultPolicy.
+var (
+	BearerTokenPattern = Pattern{Name: "bearer", Regexp: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)}
+	JWTPattern         = Pattern{Name: "jwt", Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)}
+	CardNumberPattern  = Pattern{Name: "card", Regexp: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhnValid}
+	EmailPattern       = Pattern{Name: "email", Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)}
+)
+
+// DefaultPolicy masks credentials, session cookies and common secrets.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		DenyHeaders: []string{
+			"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+			"X-Api-Key", "X-Auth-Token", "X-Csrf-Token", "X-Amz-Security-Token",
+		},
+		QueryParams: []string{
+			"access_token", "refresh_token", "id_token", "token", "api_key", "apikey",
+			"key", "password", "secret", "client_secret", "signature", "sig",
+		},
+		BodyFields: []string{
+			"password", "passwd", "secret", "token", "access_token", "refresh_token",
+			"id_token", "api_key", "apikey", "authorization", "client_secret",
+			"card_number", "cardNumber", "cvv", "cvc",
+		},
+		Patterns: []Pattern{BearerTokenPattern, JWTPattern, CardNumberPattern, EmailPattern},
+	}
+}
+
+type policyHolder struct{ p *Policy }
+
+var defaultPolicy atomic.Value // policyHolder
+
+func init() {
+	defaultPolicy.Store(policyHolder{DefaultPolicy()})
+}
+
+// Default returns the policy used by log.LogHeaders and TraceMiddleware
+// unless configured otherwise.
+func Default() *Policy {
+	return defaultPolicy.Load().(policyHolder).p
+}
+
+// SetDefault replaces the default policy. Passing nil disables redaction.
+func SetDefault(p *Policy) {
+	if p == nil {
+		p = &Policy{}
+	}
+	defaultPolicy.Store(policyHolder{p})
+}
+
+func (p *Policy) mask() string {
+	if p.Mask != "" {
+		return p.Mask
+	}
+	return DefaultMask
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns a copy of h with masked values.
+func (p *Policy) Header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		if containsFold(p.DenyHeaders, k) || (len(p.AllowHeaders) > 0 && !containsFold(p.AllowHeaders, k)) {
+			out[k] = []string{p.mask()}
+			continue
+		}
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// URL renders u with masked query parameters and user info password.
+func (p *Policy) URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	c := *u
+	if c.User != nil {
+		if _, ok := c.User.Password(); ok {
+			c.User = url.UserPassword(c.User.Username(), p.mask())
+		}
+	}
+	if c.RawQuery != "" && len(p.QueryParams) > 0 {
+		c.RawQuery = p.query(c.RawQuery, p.QueryParams)
+	}
+	return c.String()
+}
+
+// query masks the listed keys of a URL encoded query, keeping the order of
+// the other parameters untouched.
+func (p *Policy) query(raw string, keys []string) string {
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		k, _, _ := strings.Cut(part, "=")
+		if name, err := url.QueryUnescape(k); err == nil && containsFold(keys, name) {
+			parts[i] = k + "=" + url.QueryEscape(p.mask())
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Body returns body with sensitive data masked according to its content type.
+// JSON bodies keep their member order; bodies that cannot be parsed (e.g.
+// because they were truncated) fall back to pattern based masking.
+func (p *Policy) Body(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if len(p.BodyFields) > 0 {
+			return []byte(p.query(string(body), p.BodyFields))
+		}
+		return body
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "":
+		if out, ok := p.jsonBody(body); ok {
+			return out
+		}
+	}
+	return p.text(body)
+}
+
+// jsonBody masks fields of a JSON object or array.
+func (p *Policy) jsonBody(body []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid(trimmed) {
+		return nil, false
+	}
+	// Wrap the document so arrays can be decoded as OrderedObject as well.
+	var wrapper utils.OrderedObject
+	if err := json.Unmarshal(append(append([]byte(`{"":`), trimmed...), '}'), &wrapper); err != nil {
+		return nil, false
+	}
+	root := p.maskFields(wrapper[0].Value)
+	for _, ptr := range p.JSONPointers {
+		root = p.maskPointer(root, pointerTokens(ptr))
+	}
+	out, err := json.Marshal(root)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (p *Policy) maskFields(v any) any {
+	switch t := v.(type) {
+	case utils.OrderedObject:
+		for i, m := range t {
+			if containsFold(p.BodyFields, m.Key) {
+				t[i].Value = p.mask()
+			} else {
+				t[i].Value = p.maskFields(m.Value)
+			}
+		}
+		return t
+	case []any:
+		for i := range t {
+			t[i] = p.maskFields(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+func (p *Policy) maskPointer(v any, tokens []string) any {
+	if len(tokens) == 0 {
+		return p.mask()
+	}
+	tok, rest := tokens[0], tokens[1:]
+	switch t := v.(type) {
+	case utils.OrderedObject:
+		for i, m := range t {
+			if tok == "*" || m.Key == tok {
+				t[i].Value = p.maskPointer(m.Value, rest)
+			}
+		}
+		return t
+	case []any:
+		for i := range t {
+			if tok == "*" || tok == strconv.Itoa(i) {
+				t[i] = p.maskPointer(t[i], rest)
+			}
+		}
+		return t
+	}
+	return v
+}
+
+// pointerTokens splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func pointerTokens(ptr string) []string {
+	if ptr == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(ptr, "/"), "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// text masks body fields written as JSON members and every pattern match.
+func (p *Policy) text(body []byte) []byte {
+	out := body
+	if len(p.BodyFields) > 0 {
+		out = p.jsonFieldsRegexp().ReplaceAll(out, []byte(`${1}"`+p.mask()+`"`))
+	}
+	for _, pat := range p.Patterns {
+		out = pat.Regexp.ReplaceAllFunc(out, func(m []byte) []byte {
+			if pat.Valid != nil && !pat.Valid(string(m)) {
+				return m
+			}
+			return []byte(p.mask())
+		})
+	}
+	return out
+}
+
+// jsonFieldsRegexp matches `"field": value` pairs of BodyFields in text that
+// is not valid JSON, such as a truncated JSON body. Strings, numbers and
+// literals are matched whole; an object or array value is matched to the end
+// of the text, as its end cannot be found without parsing. It is compiled
+// once and again only when BodyFields changes.
+func (p *Policy) jsonFieldsRegexp() *regexp.Regexp {
+	if c := p.fieldsRegexp.Load(); c != nil && slices.Equal(c.fields, p.BodyFields) {
+		return c.re
+	}
+	names := make([]string, len(p.BodyFields))
+	for i, f := range p.BodyFields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	re := regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|-?[0-9][0-9.eE+-]*|[A-Za-z]+|[{\[](?s:.*))`)
+	p.fieldsRegexp.Store(&fieldsRegexp{fields: slices.Clone(p.BodyFields), re: re})
+	return re
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
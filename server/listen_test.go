@@ -0,0 +1,42 @@
+package server_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/server"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	l, err := server.Listen(server.UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Listen(server.UnixPrefix + path); err == nil {
+		t.Fatal("listened on a socket in use")
+	}
+	// leave a stale socket file behind, as after a crash
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = server.Listen(server.UnixPrefix + path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	l.Close()
+}
+
+func TestSystemdListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := server.SystemdListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Fatalf("got %v, %v for another process", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS left in the environment")
+	}
+}
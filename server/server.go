@@ -0,0 +1,275 @@
+// Package server runs an http.Server with sane timeouts, tracing, panic
+// recovery, health endpoints and a graceful shutdown on SIGINT and SIGTERM.
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/health"
+	"github.com/Guadalsistema/net-utils/log"
+	"github.com/Guadalsistema/net-utils/middleware"
+)
+
+// DefaultAddr is the address listened on unless WithAddr says otherwise.
+const DefaultAddr = ":8080"
+
+// Timeouts of the server. Zero values disable the corresponding timeout.
+type Timeouts struct {
+	// ReadHeader bounds the time to read request headers.
+	ReadHeader time.Duration
+	// Read bounds the time to read a whole request, body included.
+	Read time.Duration
+	// Write bounds the time from the end of the request headers to the end
+	// of the response. Long streaming responses need it disabled.
+	Write time.Duration
+	// Idle bounds the time keep-alive connections wait for a next request.
+	Idle time.Duration
+}
+
+// DefaultTimeouts protect against slow clients without cutting ordinary
+// requests short.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
+// Defaults of the shutdown sequence.
+const (
+	DefaultDrainPeriod     = 5 * time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithAddr sets the address to listen on: "host:port", or a Unix domain
+// socket path prefixed with UnixPrefix. It is ignored when the process was
+// socket activated by systemd.
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.addr = addr }
+}
+
+// WithListener serves on l instead of listening on the address.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) { s.listeners = append(s.listeners, l) }
+}
+
+// WithTimeouts replaces DefaultTimeouts.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Server) { s.timeouts = t }
+}
+
+// WithDrainPeriod sets how long readiness fails before the server stops
+// accepting requests, giving load balancers time to notice.
+// DefaultDrainPeriod when unset.
+func WithDrainPeriod(d time.Duration) Option {
+	return func(s *Server) { s.drain = d }
+}
+
+// WithShutdownTimeout bounds the wait for in-flight requests once draining
+// is over; connections still open then are closed.
+// DefaultShutdownTimeout when unset.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) { s.shutdownTimeout = d }
+}
+
+// WithSignals sets the signals starting the shutdown, SIGINT and SIGTERM by
+// default.
+func WithSignals(signals ...os.Signal) Option {
+	return func(s *Server) { s.signals = signals }
+}
+
+// WithHealth serves the reports of c on the health endpoints instead of
+// those of an empty checker.
+func WithHealth(c *health.Checker) Option {
+	return func(s *Server) { s.health = c }
+}
+
+// WithTraceOptions configures the TraceMiddleware installed by the server.
+// The health endpoints are skipped already.
+func WithTraceOptions(opts ...middleware.TraceOption) Option {
+	return func(s *Server) { s.traceOpts = append(s.traceOpts, opts...) }
+}
+
+// WithRecoveryOptions configures the Recovery middleware installed by the
+// server.
+func WithRecoveryOptions(opts ...middleware.RecoveryOption) Option {
+	return func(s *Server) { s.recoveryOpts = append(s.recoveryOpts, opts...) }
+}
+
+// WithMiddleware wraps the handler in mw, the first one outermost. They run
+// inside TraceMiddleware and Recovery.
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) { s.middleware = append(s.middleware, mw...) }
+}
+
+// Server serves a handler behind TraceMiddleware and Recovery, with the
+// health endpoints of package health, until it is told to stop.
+type Server struct {
+	logger          *slog.Logger
+	handler         http.Handler
+	addr            string
+	listeners       []net.Listener
+	timeouts        Timeouts
+	drain           time.Duration
+	shutdownTimeout time.Duration
+	signals         []os.Signal
+	health          *health.Checker
+	traceOpts       []middleware.TraceOption
+	recoveryOpts    []middleware.RecoveryOption
+	middleware      []func(http.Handler) http.Handler
+
+	srv *http.Server
+}
+
+// New returns a server for h logging through l.
+func New(l *slog.Logger, h http.Handler, opts ...Option) *Server {
+	s := &Server{
+		logger:          l,
+		handler:         h,
+		addr:            DefaultAddr,
+		timeouts:        DefaultTimeouts,
+		drain:           DefaultDrainPeriod,
+		shutdownTimeout: DefaultShutdownTimeout,
+		signals:         []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.health == nil {
+		s.health = health.NewChecker(l)
+	}
+	s.srv = &http.Server{
+		Handler:           s.chain(),
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(l.Handler(), slog.LevelWarn),
+	}
+	return s
+}
+
+// Health returns the checker served on the health endpoints.
+func (s *Server) Health() *health.Checker { return s.health }
+
+// HTTPServer returns the underlying server, e.g. to set TLSConfig before
+// Run.
+func (s *Server) HTTPServer() *http.Server { return s.srv }
+
+// chain builds TraceMiddleware -> Recovery -> middleware -> health or
+// handler.
+func (s *Server) chain() http.Handler {
+	live, ready := s.health.LivenessHandler(), s.health.ReadinessHandler()
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			switch r.URL.Path {
+			case health.LivenessPath:
+				live.ServeHTTP(w, r)
+				return
+			case health.ReadinessPath, health.HealthPath:
+				ready.ServeHTTP(w, r)
+				return
+			}
+		}
+		s.handler.ServeHTTP(w, r)
+	})
+	for _, mw := range slices.Backward(s.middleware) {
+		h = mw(h)
+	}
+	h = middleware.Recovery(s.logger, s.recoveryOpts...)(h)
+	traceOpts := append([]middleware.TraceOption{middleware.WithSkipPaths(health.Paths()...)}, s.traceOpts...)
+	return middleware.TraceMiddleware(s.logger, traceOpts...)(h)
+}
+
+// listen opens the listeners: the ones given WithListener, else those passed
+// by systemd, else one on the address.
+func (s *Server) listen() error {
+	if len(s.listeners) > 0 {
+		return nil
+	}
+	listeners, err := SystemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) > 0 {
+		s.listeners = listeners
+		return nil
+	}
+	l, err := Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	s.listeners = []net.Listener{l}
+	return nil
+}
+
+// Run serves until ctx is done or one of the signals is received, then shuts
+// down gracefully: readiness fails for the drain period, the listeners are
+// closed and in-flight requests are given the shutdown timeout to complete.
+// A second signal during shutdown kills the process. Run returns nil after a
+// graceful shutdown.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.listen(); err != nil {
+		return err
+	}
+	sigCtx, stop := signal.NotifyContext(ctx, s.signals...)
+	defer stop()
+
+	errs := make(chan error, len(s.listeners))
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		log.Log(s.logger, ctx, slog.LevelInfo, "Server listening", "network", l.Addr().Network(), "address", l.Addr().String())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	var serveErr error
+	select {
+	case <-sigCtx.Done():
+		reason := "context done"
+		if ctx.Err() == nil {
+			reason = "signal"
+		}
+		log.Log(s.logger, ctx, slog.LevelInfo, "Server shutting down", "reason", reason, "drain", s.drain)
+	case serveErr = <-errs:
+		log.Log(s.logger, ctx, slog.LevelError, "Server failed", "error", serveErr)
+	}
+	stop() // a second signal kills the process
+
+	s.health.SetReady(false)
+	if serveErr == nil && s.drain > 0 {
+		time.Sleep(s.drain)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.shutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		log.Log(s.logger, ctx, slog.LevelWarn, "Server shutdown timed out, closing connections", "error", err)
+		s.srv.Close()
+	}
+	wg.Wait()
+	log.Log(s.logger, ctx, slog.LevelInfo, "Server stopped")
+	return serveErr
+}
+
+// ListenAndServe runs the server until a signal is received.
+func (s *Server) ListenAndServe() error {
+	return s.Run(context.Background())
+}
@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/server"
+)
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func listen(t *testing.T) (net.Listener, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, "http://" + l.Addr().String()
+}
+
+func get(t *testing.T, url string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestServer_DefaultMiddlewareAndHealth(t *testing.T) {
+	var logBuf syncBuffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	l, base := listen(t)
+	s := server.New(slog.New(slog.NewTextHandler(&logBuf, nil)), mux, server.WithListener(l), server.WithDrainPeriod(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	if resp := get(t, base+"/ok"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Tx-Id") == "" {
+		t.Errorf("/ok: %d, X-Tx-Id %q", resp.StatusCode, resp.Header.Get("X-Tx-Id"))
+	}
+	if resp := get(t, base+"/boom"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("/boom: %d", resp.StatusCode)
+	}
+	if resp := get(t, base+"/readyz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("/readyz: %d", resp.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	logs := logBuf.String()
+	for _, want := range []string{`msg="Server listening" network=tcp`, `msg="Panic recovered"`, `msg="Server shutting down" reason="context done"`, `msg="Server stopped"`} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("missing %s in:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "/readyz") {
+		t.Errorf("health probe logged:\n%s", logs)
+	}
+}
+
+func TestServer_SignalDrainsBeforeShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	l, base := listen(t)
+	s := server.New(slog.New(slog.DiscardHandler), h, server.WithListener(l),
+		server.WithSignals(syscall.SIGUSR1), server.WithDrainPeriod(300*time.Millisecond))
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+
+	slow := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		slow <- resp
+	}()
+	<-started
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if resp := get(t, base+"/readyz"); resp.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("readiness did not fail while draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if resp := get(t, base+"/livez"); resp.StatusCode != http.StatusOK {
+		t.Errorf("liveness while draining: %d", resp.StatusCode)
+	}
+
+	close(release)
+	if resp := <-slow; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("in-flight request not completed: %v", resp)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := http.Get(base + "/ok"); err == nil {
+		t.Fatal("server still accepting after shutdown")
+	}
+}
@@ -0,0 +1,241 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// BaggageHeader is the W3C baggage header.
+const BaggageHeader = "baggage"
+
+// Limits from the W3C baggage specification.
+const (
+	maxBaggageMembers     = 180
+	maxBaggageBytes       = 8192
+	maxBaggageMemberBytes = 4096
+)
+
+// Baggage is an immutable, ordered set of application defined key/value pairs
+// propagated alongside the trace. The zero value is empty.
+type Baggage struct {
+	members []BaggageMember
+}
+
+// BaggageMember is one list-member of the baggage header. Values are kept
+// decoded; Properties hold the raw ";"-separated metadata.
+type BaggageMember struct {
+	Key        string
+	Value      string
+	Properties []string
+}
+
+type baggageKey struct{} // unexported unique type
+
+// WithBaggage stores b in ctx.
+func WithBaggage(ctx context.Context, b Baggage) context.Context {
+	return context.WithValue(ctx, baggageKey{}, b)
+}
+
+// BaggageFrom returns the baggage of ctx, empty when none is set.
+func BaggageFrom(ctx context.Context) Baggage {
+	if ctx == nil {
+		return Baggage{}
+	}
+	b, _ := ctx.Value(baggageKey{}).(Baggage)
+	return b
+}
+
+// ParseBaggage parses a W3C baggage header, percent-decoding the values.
+func ParseBaggage(h string) (Baggage, error) {
+	if len(h) > maxBaggageBytes {
+		return Baggage{}, fmt.Errorf("baggage exceeds %d bytes", maxBaggageBytes)
+	}
+	var b Baggage
+	for _, raw := range strings.Split(h, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if len(raw) > maxBaggageMemberBytes {
+			return Baggage{}, fmt.Errorf("baggage member exceeds %d bytes", maxBaggageMemberBytes)
+		}
+		m, err := parseBaggageMember(raw)
+		if err != nil {
+			return Baggage{}, err
+		}
+		b = b.set(m)
+		if len(b.members) > maxBaggageMembers {
+			return Baggage{}, fmt.Errorf("baggage has more than %d members", maxBaggageMembers)
+		}
+	}
+	return b, nil
+}
+
+func parseBaggageMember(raw string) (BaggageMember, error) {
+	parts := strings.Split(raw, ";")
+	key, value, ok := strings.Cut(parts[0], "=")
+	if !ok {
+		return BaggageMember{}, fmt.Errorf("invalid baggage member %q", raw)
+	}
+	key = strings.TrimSpace(key)
+	if !isToken(key) {
+		return BaggageMember{}, fmt.Errorf("invalid baggage key %q", key)
+	}
+	decoded, err := url.PathUnescape(strings.TrimSpace(value))
+	if err != nil {
+		return BaggageMember{}, fmt.Errorf("invalid baggage value for %q: %w", key, err)
+	}
+	m := BaggageMember{Key: key, Value: decoded}
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pk, _, _ := strings.Cut(p, "=")
+		if !isToken(strings.TrimSpace(pk)) {
+			return BaggageMember{}, fmt.Errorf("invalid baggage property %q", p)
+		}
+		m.Properties = append(m.Properties, p)
+	}
+	return m, nil
+}
+
+// String formats the baggage as a header value. Members that would push the
+// header over the specification limits are left out.
+func (b Baggage) String() string {
+	var sb strings.Builder
+	n := 0
+	for _, m := range b.members {
+		entry := m.Key + "=" + encodeBaggageValue(m.Value)
+		for _, p := range m.Properties {
+			entry += ";" + p
+		}
+		if len(entry) > maxBaggageMemberBytes || n >= maxBaggageMembers {
+			continue
+		}
+		extra := len(entry)
+		if sb.Len() > 0 {
+			extra++
+		}
+		if sb.Len()+extra > maxBaggageBytes {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(entry)
+		n++
+	}
+	return sb.String()
+}
+
+func (b Baggage) Len() int { return len(b.members) }
+
+// Members returns a copy of the members in order.
+func (b Baggage) Members() []BaggageMember {
+	return append([]BaggageMember(nil), b.members...)
+}
+
+// Get returns the decoded value stored under key.
+func (b Baggage) Get(key string) (string, bool) {
+	for _, m := range b.members {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set returns a copy of b with key set to value.
+func (b Baggage) Set(key, value string) (Baggage, error) {
+	if !isToken(key) {
+		return b, fmt.Errorf("invalid baggage key %q", key)
+	}
+	return b.set(BaggageMember{Key: key, Value: value}), nil
+}
+
+// set replaces the member with the same key in place, or appends it.
+func (b Baggage) set(m BaggageMember) Baggage {
+	members := make([]BaggageMember, 0, len(b.members)+1)
+	replaced := false
+	for _, old := range b.members {
+		if old.Key == m.Key {
+			members = append(members, m)
+			replaced = true
+			continue
+		}
+		members = append(members, old)
+	}
+	if !replaced {
+		members = append(members, m)
+	}
+	return Baggage{members: members}
+}
+
+// Delete returns a copy of b without key.
+func (b Baggage) Delete(key string) Baggage {
+	var members []BaggageMember
+	for _, m := range b.members {
+		if m.Key != key {
+			members = append(members, m)
+		}
+	}
+	return Baggage{members: members}
+}
+
+// isToken reports whether s is an RFC 7230 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeBaggageValue percent-encodes every byte outside the baggage-octet
+// range, plus "%" itself.
+func encodeBaggageValue(v string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c > ' ' && c < 0x7f && c != '"' && c != ',' && c != ';' && c != '\\' && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&0x0f])
+	}
+	return sb.String()
+}
+
+// BaggagePropagator implements the W3C baggage header.
+type BaggagePropagator struct{}
+
+func (BaggagePropagator) Inject(ctx context.Context, c Carrier) {
+	if h := BaggageFrom(ctx).String(); h != "" {
+		c.Set(BaggageHeader, h)
+	}
+}
+
+func (BaggagePropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	h := c.Get(BaggageHeader)
+	if h == "" {
+		return ctx
+	}
+	b, err := ParseBaggage(h)
+	if err != nil || b.Len() == 0 {
+		return ctx
+	}
+	return WithBaggage(ctx, b)
+}
+
+func (BaggagePropagator) Fields() []string { return []string{BaggageHeader} }
@@ -0,0 +1,91 @@
+package trace_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestParseBaggage(t *testing.T) {
+	b, err := trace.ParseBaggage("tenant=acme, user=j%C3%B6rg;ttl=60 ,cohort=beta%2Cgamma")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("expected 3 members, got %d", b.Len())
+	}
+	if v, _ := b.Get("user"); v != "jörg" {
+		t.Errorf("user = %q, want decoded value", v)
+	}
+	if v, _ := b.Get("cohort"); v != "beta,gamma" {
+		t.Errorf("cohort = %q", v)
+	}
+	if props := b.Members()[1].Properties; len(props) != 1 || props[0] != "ttl=60" {
+		t.Errorf("unexpected properties: %v", props)
+	}
+	if got := b.String(); got != "tenant=acme,user=j%C3%B6rg;ttl=60,cohort=beta%2Cgamma" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"novalue", "bad key=1", "k=%zz", "k=1;(x)"} {
+		if _, err := trace.ParseBaggage(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	if _, err := trace.ParseBaggage("k=" + strings.Repeat("x", 8192)); err == nil {
+		t.Errorf("expected error for oversized header")
+	}
+}
+
+func TestBaggageSetDeleteAndLimits(t *testing.T) {
+	var b trace.Baggage
+	b, err := b.Set("tenant", "a b%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = b.Set("user", "42")
+	b, _ = b.Set("tenant", "acme")
+	if got := b.String(); got != "tenant=acme,user=42" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := b.Delete("tenant").String(); got != "user=42" {
+		t.Errorf("after Delete String() = %q", got)
+	}
+	if _, err := b.Set("bad key", "x"); err == nil {
+		t.Errorf("expected error for invalid key")
+	}
+
+	b, _ = b.Set("space", "a b%")
+	if v, _ := b.Get("space"); v != "a b%" {
+		t.Errorf("Get should return the raw value, got %q", v)
+	}
+	if !strings.Contains(b.String(), "space=a%20b%25") {
+		t.Errorf("value not percent-encoded: %q", b.String())
+	}
+
+	big, _ := trace.Baggage{}.Set("big", strings.Repeat("x", 5000))
+	big, _ = big.Set("small", "1")
+	if got := big.String(); got != "small=1" {
+		t.Errorf("oversized member should be dropped on serialisation, got %q", got)
+	}
+}
+
+func TestBaggagePropagator(t *testing.T) {
+	ctx := trace.BaggagePropagator{}.Extract(context.Background(), trace.MapCarrier{"baggage": "tenant=acme"})
+	if v, ok := trace.BaggageFrom(ctx).Get("tenant"); !ok || v != "acme" {
+		t.Fatalf("baggage not extracted")
+	}
+
+	out := trace.MapCarrier{}
+	trace.BaggagePropagator{}.Inject(ctx, out)
+	if out["baggage"] != "tenant=acme" {
+		t.Errorf("injected baggage = %q", out["baggage"])
+	}
+
+	ctx = trace.BaggagePropagator{}.Extract(context.Background(), trace.MapCarrier{"baggage": "invalid"})
+	if trace.BaggageFrom(ctx).Len() != 0 {
+		t.Errorf("invalid baggage must be ignored")
+	}
+}
@@ -0,0 +1,217 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxQueueSize  = 2048
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	defaultExportTimeout = 30 * time.Second
+)
+
+type batchConfig struct {
+	maxQueueSize  int
+	batchSize     int
+	flushInterval time.Duration
+	exportTimeout time.Duration
+}
+
+// BatchOption configures a BatchProcessor.
+type BatchOption func(*batchConfig)
+
+// WithMaxQueueSize bounds the number of spans waiting to be exported. Spans
+// ending while the queue is full are dropped.
+func WithMaxQueueSize(n int) BatchOption {
+	return func(c *batchConfig) { c.maxQueueSize = n }
+}
+
+// WithBatchSize sets the maximum number of spans per export call.
+func WithBatchSize(n int) BatchOption {
+	return func(c *batchConfig) { c.batchSize = n }
+}
+
+// WithFlushInterval sets how often a partial batch is exported.
+func WithFlushInterval(d time.Duration) BatchOption {
+	return func(c *batchConfig) { c.flushInterval = d }
+}
+
+// WithExportTimeout bounds a single export call. Values that are not
+// positive keep the default.
+func WithExportTimeout(d time.Duration) BatchOption {
+	return func(c *batchConfig) { c.exportTimeout = d }
+}
+
+// BatchProcessor queues ended spans and exports them in batches from a
+// background goroutine, so ending a span never blocks on the network.
+type BatchProcessor struct {
+	exporter Exporter
+	cfg      batchConfig
+
+	queue chan SpanData
+	flush chan chan error
+	stop  chan struct{}
+	done  chan struct{}
+
+	// mu orders OnEnd against Shutdown: no span is queued once the loop
+	// may have drained the queue for the last time.
+	mu      sync.RWMutex
+	stopped bool
+	dropped atomic.Uint64
+}
+
+// NewBatchProcessor starts a processor exporting to exp.
+func NewBatchProcessor(exp Exporter, opts ...BatchOption) *BatchProcessor {
+	cfg := batchConfig{
+		maxQueueSize:  defaultMaxQueueSize,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		exportTimeout: defaultExportTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = defaultBatchSize
+	}
+	if cfg.maxQueueSize < cfg.batchSize {
+		cfg.maxQueueSize = cfg.batchSize
+	}
+	if cfg.flushInterval <= 0 {
+		cfg.flushInterval = defaultFlushInterval
+	}
+	if cfg.exportTimeout <= 0 {
+		cfg.exportTimeout = defaultExportTimeout
+	}
+
+	bp := &BatchProcessor{
+		exporter: exp,
+		cfg:      cfg,
+		queue:    make(chan SpanData, cfg.maxQueueSize),
+		flush:    make(chan chan error),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go bp.loop()
+	return bp
+}
+
+// OnEnd enqueues s without blocking. It drops the span when the queue is full
+// or the processor has been shut down.
+func (bp *BatchProcessor) OnEnd(s SpanData) {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	if bp.stopped {
+		bp.dropped.Add(1)
+		return
+	}
+	select {
+	case bp.queue <- s:
+	default:
+		bp.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans discarded because the queue was full
+// or the processor was shut down.
+func (bp *BatchProcessor) Dropped() uint64 { return bp.dropped.Load() }
+
+// ForceFlush exports every queued span and waits for the export to finish.
+func (bp *BatchProcessor) ForceFlush(ctx context.Context) error {
+	ch := make(chan error, 1)
+	select {
+	case bp.flush <- ch:
+	case <-bp.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting spans, drains the queue and shuts the exporter down.
+func (bp *BatchProcessor) Shutdown(ctx context.Context) error {
+	bp.mu.Lock()
+	if !bp.stopped {
+		bp.stopped = true
+		close(bp.stop)
+	}
+	bp.mu.Unlock()
+	select {
+	case <-bp.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return bp.exporter.Shutdown(ctx)
+}
+
+func (bp *BatchProcessor) loop() {
+	defer close(bp.done)
+	ticker := time.NewTicker(bp.cfg.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, bp.cfg.batchSize)
+	export := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), bp.cfg.exportTimeout)
+		defer cancel()
+		err := bp.exporter.ExportSpans(ctx, batch)
+		batch = make([]SpanData, 0, bp.cfg.batchSize) // exporters may keep the slice
+		return err
+	}
+	// drain moves every queued span into batches, exporting full ones.
+	drain := func() error {
+		var firstErr error
+		for {
+			select {
+			case s := <-bp.queue:
+				batch = append(batch, s)
+				if len(batch) >= bp.cfg.batchSize {
+					if err := export(); err != nil && firstErr == nil {
+						firstErr = err
+					}
+				}
+			default:
+				if err := export(); err != nil && firstErr == nil {
+					firstErr = err
+				}
+				return firstErr
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-bp.queue:
+			batch = append(batch, s)
+			if len(batch) >= bp.cfg.batchSize {
+				reportExportError(export())
+			}
+		case <-ticker.C:
+			reportExportError(export())
+		case ch := <-bp.flush:
+			ch <- drain()
+		case <-bp.stop:
+			reportExportError(drain())
+			return
+		}
+	}
+}
+
+func reportExportError(err error) {
+	if err != nil {
+		slog.Error("trace: failed to export spans", "error", err)
+	}
+}
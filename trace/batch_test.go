@@ -0,0 +1,192 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func endedSpan(name string) trace.SpanData {
+	_, s := trace.Start(context.Background(), name)
+	s.End()
+	return s.Snapshot()
+}
+
+func TestSpanEndExportsThroughProcessor(t *testing.T) {
+	exp := trace.NewInMemoryExporter()
+	trace.SetProcessor(trace.NewSimpleProcessor(exp))
+	t.Cleanup(func() { trace.SetProcessor(nil) })
+
+	ctx, parent := trace.Start(context.Background(), "parent")
+	_, child := trace.Start(ctx, "child")
+	child.End()
+	parent.End()
+	parent.End() // second End must not export twice
+
+	unsampled, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, dropped := trace.Start(trace.WithSpanContext(context.Background(), unsampled), "unsampled")
+	dropped.End()
+
+	spans := exp.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Errorf("unexpected export order: %s, %s", spans[0].Name, spans[1].Name)
+	}
+	if spans[0].Parent.SpanID != spans[1].SpanContext.SpanID {
+		t.Errorf("child parent link lost in export")
+	}
+}
+
+func TestBatchProcessorBatchesAndFlushes(t *testing.T) {
+	exp := trace.NewInMemoryExporter()
+	bp := trace.NewBatchProcessor(exp, trace.WithBatchSize(2), trace.WithFlushInterval(time.Hour))
+
+	for _, name := range []string{"a", "b", "c"} {
+		bp.OnEnd(endedSpan(name))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(exp.Spans()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(exp.Spans()); got != 2 {
+		t.Fatalf("expected a full batch of 2 to be exported, got %d", got)
+	}
+
+	if err := bp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush failed: %v", err)
+	}
+	if got := len(exp.Spans()); got != 3 {
+		t.Fatalf("expected 3 spans after flush, got %d", got)
+	}
+
+	if err := bp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	bp.OnEnd(endedSpan("late"))
+	if got := len(exp.Spans()); got != 3 {
+		t.Errorf("spans ending after shutdown must be dropped, got %d", got)
+	}
+}
+
+// blockingExporter blocks every export until release is closed.
+type blockingExporter struct {
+	trace.InMemoryExporter
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingExporter) ExportSpans(ctx context.Context, spans []trace.SpanData) error {
+	e.once.Do(func() { close(e.started) })
+	<-e.release
+	return e.InMemoryExporter.ExportSpans(ctx, spans)
+}
+
+func TestBatchProcessorBoundedQueueAndDrain(t *testing.T) {
+	exp := &blockingExporter{started: make(chan struct{}), release: make(chan struct{})}
+	bp := trace.NewBatchProcessor(exp, trace.WithBatchSize(1), trace.WithMaxQueueSize(2), trace.WithFlushInterval(time.Hour))
+
+	bp.OnEnd(endedSpan("in-flight"))
+	<-exp.started // the loop is now blocked exporting the first span
+
+	for i := 0; i < 5; i++ {
+		bp.OnEnd(endedSpan("queued"))
+	}
+	if bp.Dropped() != 3 {
+		t.Errorf("expected 3 dropped spans, got %d", bp.Dropped())
+	}
+
+	close(exp.release)
+	if err := bp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if got := len(exp.Spans()); got != 3 {
+		t.Errorf("expected shutdown to drain 3 spans, got %d", got)
+	}
+}
+
+func TestBatchProcessorShutdownRace(t *testing.T) {
+	for range 50 {
+		exp := &trace.InMemoryExporter{}
+		bp := trace.NewBatchProcessor(exp, trace.WithMaxQueueSize(1000), trace.WithFlushInterval(time.Hour))
+
+		// every span is either exported or counted as dropped, however
+		// OnEnd and Shutdown interleave
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 50 {
+					bp.OnEnd(endedSpan("racing"))
+				}
+			}()
+		}
+		if err := bp.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown failed: %v", err)
+		}
+		wg.Wait()
+		if got := uint64(len(exp.Spans())) + bp.Dropped(); got != 200 {
+			t.Fatalf("exported + dropped = %d, want 200", got)
+		}
+	}
+}
+
+// contextExporter fails exports whose context is already done.
+type contextExporter struct{ trace.InMemoryExporter }
+
+func (e *contextExporter) ExportSpans(ctx context.Context, spans []trace.SpanData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.InMemoryExporter.ExportSpans(ctx, spans)
+}
+
+func TestBatchProcessorInvalidExportTimeout(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		exp := &contextExporter{}
+		bp := trace.NewBatchProcessor(exp, trace.WithExportTimeout(d), trace.WithFlushInterval(time.Hour))
+		bp.OnEnd(endedSpan("op"))
+		if err := bp.Shutdown(context.Background()); err != nil {
+			t.Fatalf("WithExportTimeout(%v): Shutdown failed: %v", d, err)
+		}
+		if got := len(exp.Spans()); got != 1 {
+			t.Errorf("WithExportTimeout(%v): exported %d spans, want 1", d, got)
+		}
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := trace.NewJSONExporter(&buf)
+
+	_, s := trace.Start(context.Background(), "op", trace.WithAttributes(slog.String("k", "v"), slog.Int("n", 1)))
+	s.End()
+	if err := exp.ExportSpans(context.Background(), []trace.SpanData{s.Snapshot(), s.Snapshot()}); err != nil {
+		t.Fatalf("ExportSpans failed: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 JSON lines, got %d: %s", len(lines), buf.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal(lines[0], &got); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if got["name"] != "op" || got["traceId"] != s.SpanContext().TraceID.String() {
+		t.Errorf("unexpected span line: %s", lines[0])
+	}
+	if !bytes.HasPrefix(lines[0], []byte(`{"traceId":`)) {
+		t.Errorf("expected members in stable order, got %s", lines[0])
+	}
+}
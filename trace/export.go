@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanData is an immutable snapshot of an ended span, as handed to exporters.
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	Parent      SpanContext
+	StartTime   time.Time
+	EndTime     time.Time
+	Attributes  []slog.Attr
+	Events      []Event
+	Status      Status
+}
+
+// Exporter ships batches of ended spans to a backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// SpanProcessor receives every sampled span when it ends.
+type SpanProcessor interface {
+	OnEnd(s SpanData)
+	ForceFlush(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+type processorHolder struct{ p SpanProcessor }
+
+var globalProcessor atomic.Value // processorHolder
+
+// SetProcessor installs the processor that receives ended spans. Passing nil
+// disables exporting. It does not shut down the previous processor.
+func SetProcessor(p SpanProcessor) {
+	globalProcessor.Store(processorHolder{p})
+}
+
+// Processor returns the installed processor, or nil.
+func Processor() SpanProcessor {
+	h, _ := globalProcessor.Load().(processorHolder)
+	return h.p
+}
+
+// snapshotLocked copies the span state; s.mu must be held.
+func (s *Span) snapshotLocked() SpanData {
+	return SpanData{
+		Name:        s.name,
+		Kind:        s.kind,
+		SpanContext: s.sc,
+		Parent:      s.parent,
+		StartTime:   s.start,
+		EndTime:     s.end,
+		Attributes:  append([]slog.Attr(nil), s.attrs...),
+		Events:      append([]Event(nil), s.events...),
+		Status:      s.status,
+	}
+}
+
+// Snapshot returns a copy of the current span state.
+func (s *Span) Snapshot() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+// SimpleProcessor exports every span synchronously as it ends. It is meant
+// for tests and debugging; use a BatchProcessor in production.
+type SimpleProcessor struct {
+	exporter Exporter
+	mu       sync.Mutex
+}
+
+func NewSimpleProcessor(exp Exporter) *SimpleProcessor {
+	return &SimpleProcessor{exporter: exp}
+}
+
+func (p *SimpleProcessor) OnEnd(s SpanData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.exporter.ExportSpans(context.Background(), []SpanData{s}); err != nil {
+		slog.Error("trace: failed to export span", "error", err)
+	}
+}
+
+func (p *SimpleProcessor) ForceFlush(ctx context.Context) error { return nil }
+
+func (p *SimpleProcessor) Shutdown(ctx context.Context) error {
+	return p.exporter.Shutdown(ctx)
+}
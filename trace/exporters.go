@@ -0,0 +1,132 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+// InMemoryExporter keeps exported spans in memory. It is meant for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *InMemoryExporter) Shutdown(ctx context.Context) error { return nil }
+
+// Spans returns a copy of every span exported so far.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset forgets the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONExporter writes each span as one JSON object per line (JSONL).
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONExporter returns an exporter writing JSON lines to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// NewStdoutExporter returns an exporter writing JSON lines to standard output.
+func NewStdoutExporter() *JSONExporter {
+	return NewJSONExporter(os.Stdout)
+}
+
+func (e *JSONExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		if err := enc.Encode(spanObject(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *JSONExporter) Shutdown(ctx context.Context) error { return nil }
+
+// spanObject renders a span with a stable member order.
+func spanObject(s SpanData) utils.OrderedObject {
+	obj := utils.OrderedObject{
+		{Key: "traceId", Value: s.SpanContext.TraceID.String()},
+		{Key: "spanId", Value: s.SpanContext.SpanID.String()},
+	}
+	if s.Parent.IsValid() {
+		obj = append(obj, utils.ObjectMember{Key: "parentSpanId", Value: s.Parent.SpanID.String()})
+	}
+	obj = append(obj,
+		utils.ObjectMember{Key: "name", Value: s.Name},
+		utils.ObjectMember{Key: "kind", Value: s.Kind.String()},
+		utils.ObjectMember{Key: "start", Value: s.StartTime.Format(time.RFC3339Nano)},
+		utils.ObjectMember{Key: "end", Value: s.EndTime.Format(time.RFC3339Nano)},
+		utils.ObjectMember{Key: "durationMs", Value: float64(s.EndTime.Sub(s.StartTime)) / float64(time.Millisecond)},
+		utils.ObjectMember{Key: "status", Value: s.Status.Code.String()},
+	)
+	if s.Status.Description != "" {
+		obj = append(obj, utils.ObjectMember{Key: "statusMessage", Value: s.Status.Description})
+	}
+	if len(s.Attributes) > 0 {
+		obj = append(obj, utils.ObjectMember{Key: "attributes", Value: attrsObject(s.Attributes)})
+	}
+	if len(s.Events) > 0 {
+		events := make([]utils.OrderedObject, len(s.Events))
+		for i, ev := range s.Events {
+			events[i] = utils.OrderedObject{
+				{Key: "name", Value: ev.Name},
+				{Key: "time", Value: ev.Time.Format(time.RFC3339Nano)},
+			}
+			if len(ev.Attributes) > 0 {
+				events[i] = append(events[i], utils.ObjectMember{Key: "attributes", Value: attrsObject(ev.Attributes)})
+			}
+		}
+		obj = append(obj, utils.ObjectMember{Key: "events", Value: events})
+	}
+	return obj
+}
+
+// attrsObject converts slog attributes into an ordered JSON object.
+func attrsObject(attrs []slog.Attr) utils.OrderedObject {
+	obj := make(utils.OrderedObject, 0, len(attrs))
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		switch v.Kind() {
+		case slog.KindGroup:
+			obj = append(obj, utils.ObjectMember{Key: a.Key, Value: attrsObject(v.Group())})
+		case slog.KindDuration, slog.KindTime:
+			obj = append(obj, utils.ObjectMember{Key: a.Key, Value: v.String()})
+		default:
+			obj = append(obj, utils.ObjectMember{Key: a.Key, Value: v.Any()})
+		}
+	}
+	return obj
+}
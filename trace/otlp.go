@@ -0,0 +1,268 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultOTLPEndpoint is the traces endpoint of a collector on the local host.
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+const otlpScopeName = "github.com/Guadalsistema/net-utils/trace"
+
+// OTLP span flag bits describing whether the parent is remote.
+const (
+	otlpFlagHasIsRemote = 0x100
+	otlpFlagIsRemote    = 0x200
+)
+
+// ErrExporterShutdown is returned when exporting after Shutdown.
+var ErrExporterShutdown = errors.New("exporter is shut down")
+
+// OTLPExporter sends spans to an OpenTelemetry collector using the OTLP/HTTP
+// protocol with JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	headers  http.Header
+	resource []slog.Attr
+	closed   atomic.Bool
+}
+
+// OTLPOption configures an OTLPExporter.
+type OTLPOption func(*OTLPExporter)
+
+// WithOTLPClient sets the HTTP client used for exports.
+func WithOTLPClient(c *http.Client) OTLPOption {
+	return func(e *OTLPExporter) { e.client = c }
+}
+
+// WithOTLPHeader adds a header, such as an API key, to every export request.
+func WithOTLPHeader(key, value string) OTLPOption {
+	return func(e *OTLPExporter) { e.headers.Add(key, value) }
+}
+
+// WithServiceName sets the service.name resource attribute.
+func WithServiceName(name string) OTLPOption {
+	return WithResourceAttributes(slog.String("service.name", name))
+}
+
+// WithResourceAttributes adds attributes describing the process emitting spans.
+func WithResourceAttributes(attrs ...slog.Attr) OTLPOption {
+	return func(e *OTLPExporter) { e.resource = append(e.resource, attrs...) }
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint, the full URL of the
+// collector traces resource (e.g. DefaultOTLPEndpoint).
+func NewOTLPExporter(endpoint string, opts ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		headers:  http.Header{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	if e.closed.Load() {
+		return ErrExporterShutdown
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("encoding OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating OTLP request: %w", err)
+	}
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending OTLP request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OTLP collector returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.closed.Store(true)
+	return nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*  OTLP/JSON wire format                                                     */
+/* -------------------------------------------------------------------------- */
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"` // int64 is a string in OTLP/JSON
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	KvlistValue *otlpKeyValList `json:"kvlistValue,omitempty"`
+}
+
+type otlpKeyValList struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+func (e *OTLPExporter) request(spans []SpanData) otlpTraceRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpanFrom(s)
+	}
+	return otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: otlpScopeName},
+			Spans: out,
+		}},
+	}}}
+}
+
+func otlpSpanFrom(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		TraceState:        s.SpanContext.TraceState.String(),
+		Flags:             uint32(s.SpanContext.TraceFlags) | otlpFlagHasIsRemote,
+		Name:              s.Name,
+		Kind:              int(s.Kind),
+		StartTimeUnixNano: unixNano(s.StartTime),
+		EndTimeUnixNano:   unixNano(s.EndTime),
+		Attributes:        otlpAttributes(s.Attributes),
+		Status:            otlpStatus{Code: int(s.Status.Code), Message: s.Status.Description},
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.SpanID.String()
+		if s.Parent.Remote {
+			span.Flags |= otlpFlagIsRemote
+		}
+	}
+	for _, ev := range s.Events {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: unixNano(ev.Time),
+			Name:         ev.Name,
+			Attributes:   otlpAttributes(ev.Attributes),
+		})
+	}
+	return span
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attrs []slog.Attr) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: otlpValue(a.Value)})
+	}
+	return kvs
+}
+
+func otlpValue(v slog.Value) otlpAnyValue {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindBool:
+		b := v.Bool()
+		return otlpAnyValue{BoolValue: &b}
+	case slog.KindInt64:
+		i := strconv.FormatInt(v.Int64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case slog.KindUint64:
+		i := strconv.FormatUint(v.Uint64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case slog.KindDuration:
+		i := strconv.FormatInt(int64(v.Duration()), 10)
+		return otlpAnyValue{IntValue: &i}
+	case slog.KindFloat64:
+		f := v.Float64()
+		return otlpAnyValue{DoubleValue: &f}
+	case slog.KindGroup:
+		return otlpAnyValue{KvlistValue: &otlpKeyValList{Values: otlpAttributes(v.Group())}}
+	default:
+		s := v.String()
+		return otlpAnyValue{StringValue: &s}
+	}
+}
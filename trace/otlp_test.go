@@ -0,0 +1,124 @@
+package trace_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var got struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string         `json:"key"`
+					Value map[string]any `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string `json:"traceId"`
+					SpanID            string `json:"spanId"`
+					ParentSpanID      string `json:"parentSpanId"`
+					Name              string `json:"name"`
+					Kind              int    `json:"kind"`
+					StartTimeUnixNano string `json:"startTimeUnixNano"`
+					Attributes        []struct {
+						Key   string         `json:"key"`
+						Value map[string]any `json:"value"`
+					} `json:"attributes"`
+					Status struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var gotAuth, gotContentType string
+
+	// A minimal collector stand-in.
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		gotAuth = r.Header.Get("Authorization")
+		gotContentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("collector failed to decode request: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	exp := trace.NewOTLPExporter(collector.URL+"/v1/traces",
+		trace.WithServiceName("orders"),
+		trace.WithOTLPHeader("Authorization", "Bearer t"))
+
+	ctx, parent := trace.Start(context.Background(), "GET", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := trace.Start(ctx, "query", trace.WithAttributes(slog.Int("rows", 3), slog.String("db", "main")))
+	child.SetStatus(trace.StatusError, "timeout")
+	child.End()
+	parent.End()
+
+	if err := exp.ExportSpans(context.Background(), []trace.SpanData{child.Snapshot(), parent.Snapshot()}); err != nil {
+		t.Fatalf("ExportSpans failed: %v", err)
+	}
+
+	if gotContentType != "application/json" || gotAuth != "Bearer t" {
+		t.Errorf("unexpected headers: content-type=%q authorization=%q", gotContentType, gotAuth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request shape: %+v", got)
+	}
+	res := got.ResourceSpans[0].Resource.Attributes
+	if len(res) != 1 || res[0].Key != "service.name" || res[0].Value["stringValue"] != "orders" {
+		t.Errorf("unexpected resource attributes: %+v", res)
+	}
+
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != parent.SpanContext().TraceID.String() || c.ParentSpanID != p.SpanID {
+		t.Errorf("parent/child link lost: %+v / %+v", c, p)
+	}
+	if p.Kind != 2 || c.Kind != 1 {
+		t.Errorf("unexpected kinds: parent=%d child=%d", p.Kind, c.Kind)
+	}
+	if c.Status.Code != 2 {
+		t.Errorf("expected error status code 2, got %d", c.Status.Code)
+	}
+	if _, err := strconv.ParseInt(c.StartTimeUnixNano, 10, 64); err != nil {
+		t.Errorf("start time must be a decimal string: %q", c.StartTimeUnixNano)
+	}
+	if len(c.Attributes) != 2 || c.Attributes[0].Value["intValue"] != "3" || c.Attributes[1].Value["stringValue"] != "main" {
+		t.Errorf("unexpected attributes: %+v", c.Attributes)
+	}
+}
+
+func TestOTLPExporterErrors(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exp := trace.NewOTLPExporter(collector.URL + "/v1/traces")
+	spans := []trace.SpanData{endedSpan("op")}
+
+	if err := exp.ExportSpans(context.Background(), spans); err == nil {
+		t.Fatalf("expected error for 503 response")
+	}
+
+	exp.Shutdown(context.Background())
+	if err := exp.ExportSpans(context.Background(), spans); !errors.Is(err, trace.ErrExporterShutdown) {
+		t.Fatalf("expected ErrExporterShutdown, got %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Carrier is the medium a Propagator reads and writes, usually HTTP headers.
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+	Keys() []string
+}
+
+// HeaderCarrier adapts http.Header to the Carrier interface.
+type HeaderCarrier http.Header
+
+func (h HeaderCarrier) Get(key string) string { return http.Header(h).Get(key) }
+func (h HeaderCarrier) Set(key, value string) { http.Header(h).Set(key, value) }
+func (h HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// MapCarrier is a Carrier backed by a map with case-sensitive keys, useful for
+// message queues and tests.
+type MapCarrier map[string]string
+
+func (m MapCarrier) Get(key string) string { return m[key] }
+func (m MapCarrier) Set(key, value string) { m[key] = value }
+func (m MapCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Propagator moves trace context in and out of a Carrier.
+type Propagator interface {
+	// Inject writes the trace context of ctx into c.
+	Inject(ctx context.Context, c Carrier)
+	// Extract returns ctx enriched with the trace context found in c. It
+	// returns ctx unchanged when c holds nothing valid.
+	Extract(ctx context.Context, c Carrier) context.Context
+	// Fields lists the keys the propagator writes.
+	Fields() []string
+}
+
+type propagatorHolder struct{ p Propagator }
+
+var defaultPropagator atomic.Value // propagatorHolder
+
+// DefaultPropagator returns the propagator used by TraceMiddleware and
+// TraceTransport unless configured otherwise. Out of the box it handles the
+// legacy X-Tx-Id header, W3C Trace Context and W3C Baggage.
+func DefaultPropagator() Propagator {
+	if h, ok := defaultPropagator.Load().(propagatorHolder); ok && h.p != nil {
+		return h.p
+	}
+	return NewCompositePropagator(TxIdPropagator{}, TraceContextPropagator{}, BaggagePropagator{})
+}
+
+// SetDefaultPropagator replaces the default propagator. Passing nil restores
+// the built-in default.
+func SetDefaultPropagator(p Propagator) {
+	defaultPropagator.Store(propagatorHolder{p})
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Composite                                                                 */
+/* -------------------------------------------------------------------------- */
+
+type compositePropagator []Propagator
+
+// NewCompositePropagator combines several propagators. Inject runs all of
+// them; Extract runs them in order, so a later format overrides an earlier one
+// when both are present.
+func NewCompositePropagator(ps ...Propagator) Propagator {
+	return compositePropagator(ps)
+}
+
+func (c compositePropagator) Inject(ctx context.Context, carrier Carrier) {
+	for _, p := range c {
+		p.Inject(ctx, carrier)
+	}
+}
+
+func (c compositePropagator) Extract(ctx context.Context, carrier Carrier) context.Context {
+	for _, p := range c {
+		ctx = p.Extract(ctx, carrier)
+	}
+	return ctx
+}
+
+func (c compositePropagator) Fields() []string {
+	var fields []string
+	for _, p := range c {
+		fields = append(fields, p.Fields()...)
+	}
+	return fields
+}
+
+/* -------------------------------------------------------------------------- */
+/*  W3C Trace Context                                                         */
+/* -------------------------------------------------------------------------- */
+
+// TraceContextPropagator implements the W3C traceparent/tracestate headers.
+type TraceContextPropagator struct{}
+
+func (TraceContextPropagator) Inject(ctx context.Context, c Carrier) {
+	sc, ok := SpanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	c.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState.Len() > 0 {
+		c.Set(TracestateHeader, sc.TraceState.String())
+	}
+}
+
+func (TraceContextPropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	sc, err := ExtractTraceContext(c.Get(TraceparentHeader), c.Get(TracestateHeader))
+	if err != nil {
+		return ctx
+	}
+	return WithSpanContext(ctx, sc)
+}
+
+func (TraceContextPropagator) Fields() []string {
+	return []string{TraceparentHeader, TracestateHeader}
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Legacy X-Tx-Id                                                            */
+/* -------------------------------------------------------------------------- */
+
+// TxIdHeader is the legacy transaction ID header.
+const TxIdHeader = "X-Tx-Id"
+
+// maxTxIdLen bounds accepted legacy transaction IDs.
+const maxTxIdLen = 128
+
+// TxIdPropagator carries the transaction ID returned by TraceIdFrom in the
+// legacy X-Tx-Id header, or in Header when set.
+type TxIdPropagator struct {
+	Header string
+}
+
+func (p TxIdPropagator) header() string {
+	if p.Header != "" {
+		return p.Header
+	}
+	return TxIdHeader
+}
+
+func (p TxIdPropagator) Inject(ctx context.Context, c Carrier) {
+	if id, ok := TraceIdFrom(ctx); ok {
+		c.Set(p.header(), id)
+	}
+}
+
+func (p TxIdPropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	id := strings.TrimSpace(c.Get(p.header()))
+	if id == "" || len(id) > maxTxIdLen || strings.ContainsFunc(id, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		return ctx
+	}
+	return WithTraceId(ctx, id)
+}
+
+func (p TxIdPropagator) Fields() []string { return []string{p.header()} }
+
+/* -------------------------------------------------------------------------- */
+/*  Zipkin B3                                                                 */
+/* -------------------------------------------------------------------------- */
+
+// B3 header names.
+const (
+	B3SingleHeader       = "b3"
+	B3TraceIdHeader      = "X-B3-TraceId"
+	B3SpanIdHeader       = "X-B3-SpanId"
+	B3ParentSpanIdHeader = "X-B3-ParentSpanId"
+	B3SampledHeader      = "X-B3-Sampled"
+	B3FlagsHeader        = "X-B3-Flags"
+)
+
+// B3SinglePropagator implements the single "b3" header:
+// {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}.
+type B3SinglePropagator struct{}
+
+func (B3SinglePropagator) Inject(ctx context.Context, c Carrier) {
+	sc, ok := SpanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	c.Set(B3SingleHeader, sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+b3Sampled(sc))
+}
+
+func (B3SinglePropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	h := strings.TrimSpace(c.Get(B3SingleHeader))
+	if h == "" {
+		return ctx
+	}
+	parts := strings.Split(h, "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return ctx // a lone sampling decision carries no trace to continue
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	sc, ok := b3SpanContext(parts[0], parts[1], sampled, "")
+	if !ok {
+		return ctx
+	}
+	return WithSpanContext(ctx, sc)
+}
+
+func (B3SinglePropagator) Fields() []string { return []string{B3SingleHeader} }
+
+// B3MultiPropagator implements the X-B3-* header family.
+type B3MultiPropagator struct{}
+
+func (B3MultiPropagator) Inject(ctx context.Context, c Carrier) {
+	sc, ok := SpanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	c.Set(B3TraceIdHeader, sc.TraceID.String())
+	c.Set(B3SpanIdHeader, sc.SpanID.String())
+	c.Set(B3SampledHeader, b3Sampled(sc))
+}
+
+func (B3MultiPropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	traceId := strings.TrimSpace(c.Get(B3TraceIdHeader))
+	if traceId == "" {
+		return ctx
+	}
+	sc, ok := b3SpanContext(traceId, strings.TrimSpace(c.Get(B3SpanIdHeader)),
+		strings.TrimSpace(c.Get(B3SampledHeader)), strings.TrimSpace(c.Get(B3FlagsHeader)))
+	if !ok {
+		return ctx
+	}
+	return WithSpanContext(ctx, sc)
+}
+
+func (B3MultiPropagator) Fields() []string {
+	return []string{B3TraceIdHeader, B3SpanIdHeader, B3SampledHeader}
+}
+
+func b3Sampled(sc SpanContext) string {
+	if sc.IsSampled() {
+		return "1"
+	}
+	return "0"
+}
+
+// b3SpanContext builds a remote span context from B3 fields. 64-bit trace IDs
+// are left-padded to 128 bits. A missing sampling decision is treated as
+// sampled so the trace is not lost.
+func b3SpanContext(traceId, spanId, sampled, flags string) (SpanContext, bool) {
+	if len(traceId) == 16 {
+		traceId = strings.Repeat("0", 16) + traceId
+	}
+	tid, err := TraceIDFromHex(traceId)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sid, err := SpanIDFromHex(spanId)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{TraceID: tid, SpanID: sid, TraceFlags: FlagsSampled, Remote: true}
+	switch {
+	case flags == "1" || sampled == "d":
+		// debug implies sampled
+	case sampled == "0" || sampled == "false":
+		sc.TraceFlags = 0
+	case sampled == "" || sampled == "1" || sampled == "true":
+	default:
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Jaeger                                                                    */
+/* -------------------------------------------------------------------------- */
+
+// JaegerHeader is the Jaeger client propagation header.
+const JaegerHeader = "uber-trace-id"
+
+// JaegerPropagator implements {trace-id}:{span-id}:{parent-span-id}:{flags}.
+type JaegerPropagator struct{}
+
+func (JaegerPropagator) Inject(ctx context.Context, c Carrier) {
+	sc, ok := SpanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	flags := "0"
+	if sc.IsSampled() {
+		flags = "1"
+	}
+	c.Set(JaegerHeader, sc.TraceID.String()+":"+sc.SpanID.String()+":0:"+flags)
+}
+
+func (JaegerPropagator) Extract(ctx context.Context, c Carrier) context.Context {
+	h := strings.ToLower(strings.TrimSpace(c.Get(JaegerHeader)))
+	if h == "" {
+		return ctx
+	}
+	if unescaped, err := url.QueryUnescape(h); err == nil {
+		h = unescaped
+	}
+	parts := strings.Split(h, ":")
+	if len(parts) != 4 || len(parts[0]) > 32 || len(parts[1]) > 16 || len(parts[3]) > 2 {
+		return ctx
+	}
+	tid, err := TraceIDFromHex(leftPad(parts[0], 32))
+	if err != nil {
+		return ctx
+	}
+	sid, err := SpanIDFromHex(leftPad(parts[1], 16))
+	if err != nil {
+		return ctx
+	}
+	var flags [1]byte
+	if err := decodeHex(leftPad(parts[3], 2), flags[:]); err != nil {
+		return ctx
+	}
+	sc := SpanContext{TraceID: tid, SpanID: sid, Remote: true}
+	if flags[0]&0x03 != 0 { // sampled or debug
+		sc.TraceFlags = FlagsSampled
+	}
+	return WithSpanContext(ctx, sc)
+}
+
+func (JaegerPropagator) Fields() []string { return []string{JaegerHeader} }
+
+func leftPad(s string, n int) string {
+	if len(s) >= n {
+		return s
+	}
+	return strings.Repeat("0", n-len(s)) + s
+}
@@ -0,0 +1,132 @@
+package trace_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestPropagatorsExtract(t *testing.T) {
+	tests := []struct {
+		name       string
+		propagator trace.Propagator
+		carrier    trace.MapCarrier
+		traceID    string
+		sampled    bool
+	}{
+		{"w3c", trace.TraceContextPropagator{}, trace.MapCarrier{"traceparent": "00-" + testTraceID + "-" + testSpanID + "-01"}, testTraceID, true},
+		{"b3 single", trace.B3SinglePropagator{}, trace.MapCarrier{"b3": testTraceID + "-" + testSpanID + "-1-05e3ac9a4f6e3b90"}, testTraceID, true},
+		{"b3 single 64-bit not sampled", trace.B3SinglePropagator{}, trace.MapCarrier{"b3": "a3ce929d0e0e4736-" + testSpanID + "-0"}, "0000000000000000a3ce929d0e0e4736", false},
+		{"b3 single debug", trace.B3SinglePropagator{}, trace.MapCarrier{"b3": testTraceID + "-" + testSpanID + "-d"}, testTraceID, true},
+		{"b3 multi", trace.B3MultiPropagator{}, trace.MapCarrier{"X-B3-TraceId": testTraceID, "X-B3-SpanId": testSpanID, "X-B3-Sampled": "1"}, testTraceID, true},
+		{"b3 multi not sampled", trace.B3MultiPropagator{}, trace.MapCarrier{"X-B3-TraceId": testTraceID, "X-B3-SpanId": testSpanID, "X-B3-Sampled": "0"}, testTraceID, false},
+		{"jaeger", trace.JaegerPropagator{}, trace.MapCarrier{"uber-trace-id": testTraceID + ":" + testSpanID + ":0:1"}, testTraceID, true},
+		{"jaeger short and escaped", trace.JaegerPropagator{}, trace.MapCarrier{"uber-trace-id": "A3CE929D0E0E4736%3Af067aa0ba902b7%3A0%3A0"}, "0000000000000000a3ce929d0e0e4736", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.propagator.Extract(context.Background(), tt.carrier)
+			sc, ok := trace.SpanContextFrom(ctx)
+			if !ok {
+				t.Fatalf("no span context extracted from %v", tt.carrier)
+			}
+			if sc.TraceID.String() != tt.traceID {
+				t.Errorf("trace ID = %s, want %s", sc.TraceID, tt.traceID)
+			}
+			if sc.IsSampled() != tt.sampled {
+				t.Errorf("sampled = %v, want %v", sc.IsSampled(), tt.sampled)
+			}
+			if !sc.Remote {
+				t.Errorf("extracted span context must be remote")
+			}
+		})
+	}
+}
+
+func TestPropagatorsRejectInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		propagator trace.Propagator
+		carrier    trace.MapCarrier
+	}{
+		{"b3 sampling only", trace.B3SinglePropagator{}, trace.MapCarrier{"b3": "1"}},
+		{"b3 bad span", trace.B3SinglePropagator{}, trace.MapCarrier{"b3": testTraceID + "-xyz"}},
+		{"b3 multi missing span", trace.B3MultiPropagator{}, trace.MapCarrier{"X-B3-TraceId": testTraceID}},
+		{"jaeger wrong fields", trace.JaegerPropagator{}, trace.MapCarrier{"uber-trace-id": testTraceID + ":" + testSpanID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.propagator.Extract(context.Background(), tt.carrier)
+			if _, ok := trace.SpanContextFrom(ctx); ok {
+				t.Errorf("expected no span context for %v", tt.carrier)
+			}
+		})
+	}
+}
+
+func TestPropagatorsInjectRoundTrip(t *testing.T) {
+	ctx, span := trace.Start(context.Background(), "op")
+	want := span.SpanContext()
+
+	for _, p := range []trace.Propagator{
+		trace.TraceContextPropagator{},
+		trace.B3SinglePropagator{},
+		trace.B3MultiPropagator{},
+		trace.JaegerPropagator{},
+	} {
+		carrier := trace.HeaderCarrier(http.Header{})
+		p.Inject(ctx, carrier)
+		for _, f := range p.Fields() {
+			if f != trace.TracestateHeader && carrier.Get(f) == "" {
+				t.Errorf("%T did not set field %s", p, f)
+			}
+		}
+		got, ok := trace.SpanContextFrom(p.Extract(context.Background(), carrier))
+		if !ok || got.TraceID != want.TraceID || got.SpanID != want.SpanID || got.IsSampled() != want.IsSampled() {
+			t.Errorf("%T round trip failed: got %+v, want %+v", p, got, want)
+		}
+	}
+}
+
+func TestCompositeAndTxIdPropagator(t *testing.T) {
+	p := trace.NewCompositePropagator(trace.TxIdPropagator{}, trace.B3MultiPropagator{}, trace.TraceContextPropagator{})
+
+	carrier := trace.MapCarrier{
+		"X-Tx-Id":      "abc12345",
+		"X-B3-TraceId": "11111111111111111111111111111111",
+		"X-B3-SpanId":  testSpanID,
+		"traceparent":  "00-" + testTraceID + "-" + testSpanID + "-01",
+	}
+	ctx := p.Extract(context.Background(), carrier)
+
+	sc, _ := trace.SpanContextFrom(ctx)
+	if sc.TraceID.String() != testTraceID {
+		t.Errorf("later propagator should win, got trace ID %s", sc.TraceID)
+	}
+	if id, _ := trace.TraceIdFrom(ctx); id != "abc12345" {
+		t.Errorf("legacy transaction ID lost, got %q", id)
+	}
+
+	out := trace.MapCarrier{}
+	p.Inject(ctx, out)
+	if out["X-Tx-Id"] != "abc12345" || out["X-B3-TraceId"] != testTraceID || out["traceparent"] == "" {
+		t.Errorf("unexpected injected fields: %v", out)
+	}
+
+	if got := len(p.Fields()); got != 6 {
+		t.Errorf("expected 6 fields, got %d", got)
+	}
+
+	ctx = trace.TxIdPropagator{}.Extract(context.Background(), trace.MapCarrier{"X-Tx-Id": "bad id\n"})
+	if _, ok := trace.TraceIdFrom(ctx); ok {
+		t.Errorf("transaction IDs with control characters must be ignored")
+	}
+}
@@ -0,0 +1,233 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SamplingParameters describes a span about to be started.
+type SamplingParameters struct {
+	Parent     SpanContext // invalid for root spans
+	TraceID    TraceID
+	Name       string
+	Kind       SpanKind
+	Attributes []slog.Attr
+}
+
+// Attr returns the string form of the attribute with the given key.
+func (p SamplingParameters) Attr(key string) (string, bool) {
+	for _, a := range p.Attributes {
+		if a.Key == key {
+			return a.Value.String(), true
+		}
+	}
+	return "", false
+}
+
+// Sampler decides whether a new span is sampled. The decision is stored in
+// the sampled bit of the span's TraceFlags and propagated downstream.
+type Sampler interface {
+	ShouldSample(p SamplingParameters) bool
+	Description() string
+}
+
+type samplerHolder struct{ s Sampler }
+
+var configuredSampler atomic.Value // samplerHolder
+
+// SetSampler installs the sampler used by Start. Passing nil removes it.
+func SetSampler(s Sampler) {
+	configuredSampler.Store(samplerHolder{s})
+}
+
+// ConfiguredSampler returns the sampler installed with SetSampler. When none
+// is installed Start samples like ParentBased(AlwaysSample()).
+func ConfiguredSampler() (Sampler, bool) {
+	h, _ := configuredSampler.Load().(samplerHolder)
+	return h.s, h.s != nil
+}
+
+func activeSampler() Sampler {
+	if s, ok := ConfiguredSampler(); ok {
+		return s
+	}
+	return ParentBased(AlwaysSample())
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Always / Never                                                            */
+/* -------------------------------------------------------------------------- */
+
+type constSampler bool
+
+// AlwaysSample samples every span.
+func AlwaysSample() Sampler { return constSampler(true) }
+
+// NeverSample samples no span.
+func NeverSample() Sampler { return constSampler(false) }
+
+func (c constSampler) ShouldSample(SamplingParameters) bool { return bool(c) }
+
+func (c constSampler) Description() string {
+	if c {
+		return "AlwaysOn"
+	}
+	return "AlwaysOff"
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Trace ID ratio                                                            */
+/* -------------------------------------------------------------------------- */
+
+type ratioSampler struct {
+	fraction   float64
+	upperBound uint64
+}
+
+// TraceIDRatioBased samples the given fraction of traces. The decision is
+// derived from the trace ID, so every service using the same fraction makes
+// the same decision for a trace.
+func TraceIDRatioBased(fraction float64) Sampler {
+	if fraction >= 1 {
+		return AlwaysSample()
+	}
+	if fraction <= 0 {
+		return NeverSample()
+	}
+	return ratioSampler{fraction: fraction, upperBound: uint64(fraction * (1 << 63))}
+}
+
+func (r ratioSampler) ShouldSample(p SamplingParameters) bool {
+	return binary.BigEndian.Uint64(p.TraceID[8:16])>>1 < r.upperBound
+}
+
+func (r ratioSampler) Description() string {
+	return fmt.Sprintf("TraceIDRatioBased{%g}", r.fraction)
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Parent based                                                              */
+/* -------------------------------------------------------------------------- */
+
+type parentBased struct{ root Sampler }
+
+// ParentBased follows the decision of the parent span and delegates root
+// spans to root.
+func ParentBased(root Sampler) Sampler { return parentBased{root} }
+
+func (pb parentBased) ShouldSample(p SamplingParameters) bool {
+	if p.Parent.IsValid() {
+		return p.Parent.IsSampled()
+	}
+	return pb.root.ShouldSample(p)
+}
+
+func (pb parentBased) Description() string {
+	return "ParentBased{root:" + pb.root.Description() + "}"
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Rules                                                                     */
+/* -------------------------------------------------------------------------- */
+
+// Rule selects a sampler for the spans it matches. Empty fields match
+// anything. Route matches the "http.route" attribute or the "url.path"
+// attribute exactly; a trailing "*" turns it into a path prefix.
+type Rule struct {
+	Method  string
+	Route   string
+	Sampler Sampler
+}
+
+func (r Rule) matches(p SamplingParameters) bool {
+	if r.Method != "" {
+		if m, _ := p.Attr("http.request.method"); !strings.EqualFold(m, r.Method) {
+			return false
+		}
+	}
+	if r.Route == "" {
+		return true
+	}
+	route, _ := p.Attr("http.route")
+	path, _ := p.Attr("url.path")
+	if prefix, ok := strings.CutSuffix(r.Route, "*"); ok {
+		return strings.HasPrefix(path, prefix) || (route != "" && strings.HasPrefix(route, prefix))
+	}
+	return route == r.Route || path == r.Route
+}
+
+type ruleSampler struct {
+	rules    []Rule
+	fallback Sampler
+}
+
+// RuleBased uses the sampler of the first matching rule, or fallback when no
+// rule matches.
+func RuleBased(fallback Sampler, rules ...Rule) Sampler {
+	return ruleSampler{rules: rules, fallback: fallback}
+}
+
+func (rs ruleSampler) ShouldSample(p SamplingParameters) bool {
+	for _, r := range rs.rules {
+		if r.matches(p) {
+			return r.Sampler.ShouldSample(p)
+		}
+	}
+	return rs.fallback.ShouldSample(p)
+}
+
+func (rs ruleSampler) Description() string {
+	return fmt.Sprintf("RuleBased{rules:%d,fallback:%s}", len(rs.rules), rs.fallback.Description())
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Rate limited                                                              */
+/* -------------------------------------------------------------------------- */
+
+type rateLimited struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	max    float64
+	last   time.Time
+}
+
+// RateLimited samples at most perSecond spans per second, allowing bursts of
+// up to one second worth of spans, and at least one span, so rates below one
+// per second let a span through now and then. A rate that is not positive
+// samples no span, like NeverSample.
+func RateLimited(perSecond float64) Sampler {
+	if perSecond <= 0 {
+		return NeverSample()
+	}
+	max := perSecond
+	if max < 1 {
+		max = 1
+	}
+	return &rateLimited{rate: perSecond, tokens: max, max: max, last: time.Now()}
+}
+
+func (rl *rateLimited) ShouldSample(SamplingParameters) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.max {
+		rl.tokens = rl.max
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+func (rl *rateLimited) Description() string {
+	return fmt.Sprintf("RateLimited{%g/s}", rl.rate)
+}
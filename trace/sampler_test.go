@@ -0,0 +1,132 @@
+package trace_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestConstSamplers(t *testing.T) {
+	p := trace.SamplingParameters{TraceID: trace.NewTraceID()}
+	if !trace.AlwaysSample().ShouldSample(p) || trace.NeverSample().ShouldSample(p) {
+		t.Fatalf("constant samplers are inverted")
+	}
+}
+
+func TestTraceIDRatioBased(t *testing.T) {
+	s := trace.TraceIDRatioBased(0.25)
+	sampled := 0
+	const n = 20000
+	for i := 0; i < n; i++ {
+		p := trace.SamplingParameters{TraceID: trace.NewTraceID()}
+		decision := s.ShouldSample(p)
+		if decision != s.ShouldSample(p) {
+			t.Fatalf("decision must be deterministic for a trace ID")
+		}
+		if decision {
+			sampled++
+		}
+	}
+	if ratio := float64(sampled) / n; ratio < 0.22 || ratio > 0.28 {
+		t.Errorf("sampled ratio %.3f, want about 0.25", ratio)
+	}
+	if trace.TraceIDRatioBased(1).Description() != "AlwaysOn" || trace.TraceIDRatioBased(0).Description() != "AlwaysOff" {
+		t.Errorf("bounds should collapse to constant samplers")
+	}
+}
+
+func TestParentBased(t *testing.T) {
+	s := trace.ParentBased(trace.NeverSample())
+	sampledParent := trace.NewSpanContext()
+	unsampledParent := sampledParent
+	unsampledParent.TraceFlags = 0
+
+	if !s.ShouldSample(trace.SamplingParameters{Parent: sampledParent}) {
+		t.Errorf("should follow a sampled parent")
+	}
+	if s.ShouldSample(trace.SamplingParameters{Parent: unsampledParent}) {
+		t.Errorf("should follow an unsampled parent")
+	}
+	if s.ShouldSample(trace.SamplingParameters{TraceID: trace.NewTraceID()}) {
+		t.Errorf("root spans should use the root sampler")
+	}
+}
+
+func TestRuleBased(t *testing.T) {
+	s := trace.RuleBased(trace.AlwaysSample(),
+		trace.Rule{Route: "/healthz", Sampler: trace.NeverSample()},
+		trace.Rule{Method: "POST", Route: "/api/*", Sampler: trace.NeverSample()},
+		trace.Rule{Route: "/users/{id}", Sampler: trace.NeverSample()},
+	)
+	params := func(method, path, route string) trace.SamplingParameters {
+		attrs := []slog.Attr{slog.String("http.request.method", method), slog.String("url.path", path)}
+		if route != "" {
+			attrs = append(attrs, slog.String("http.route", route))
+		}
+		return trace.SamplingParameters{Attributes: attrs}
+	}
+
+	tests := []struct {
+		name   string
+		params trace.SamplingParameters
+		want   bool
+	}{
+		{"exact path rule", params("GET", "/healthz", ""), false},
+		{"prefix with method", params("POST", "/api/orders", ""), false},
+		{"prefix other method", params("GET", "/api/orders", ""), true},
+		{"route pattern", params("GET", "/users/42", "/users/{id}"), false},
+		{"fallback", params("GET", "/other", ""), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.ShouldSample(tt.params); got != tt.want {
+				t.Errorf("ShouldSample = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	s := trace.RateLimited(2)
+	p := trace.SamplingParameters{}
+	if !s.ShouldSample(p) || !s.ShouldSample(p) {
+		t.Fatalf("expected an initial burst of 2")
+	}
+	if s.ShouldSample(p) {
+		t.Fatalf("expected the third span to be dropped")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if !s.ShouldSample(p) {
+		t.Errorf("expected a token to be refilled after 600ms")
+	}
+}
+
+func TestRateLimitedZero(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		if trace.RateLimited(rate).ShouldSample(trace.SamplingParameters{}) {
+			t.Errorf("RateLimited(%v) sampled a span", rate)
+		}
+	}
+}
+
+func TestStartUsesConfiguredSampler(t *testing.T) {
+	trace.SetSampler(trace.NeverSample())
+	t.Cleanup(func() { trace.SetSampler(nil) })
+
+	ctx, root := trace.Start(context.Background(), "root")
+	if root.SpanContext().IsSampled() {
+		t.Fatalf("root span should not be sampled")
+	}
+
+	trace.SetSampler(trace.ParentBased(trace.AlwaysSample()))
+	_, child := trace.Start(ctx, "child")
+	if child.SpanContext().IsSampled() {
+		t.Errorf("child of an unsampled span should not be sampled")
+	}
+	if child.SpanContext().Traceparent()[53:] != "00" {
+		t.Errorf("decision must be carried in the trace flags, got %s", child.SpanContext().Traceparent())
+	}
+}
@@ -0,0 +1,309 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the role of a span in a trace. Values match OTLP.
+type SpanKind int
+
+const (
+	SpanKindUnspecified SpanKind = iota
+	SpanKindInternal
+	SpanKindServer
+	SpanKindClient
+	SpanKindProducer
+	SpanKindConsumer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	case SpanKindProducer:
+		return "producer"
+	case SpanKindConsumer:
+		return "consumer"
+	default:
+		return "unspecified"
+	}
+}
+
+// StatusCode is the outcome of a span. Values match OTLP.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return "unset"
+	}
+}
+
+// Status is the status code and optional description of a span.
+type Status struct {
+	Code        StatusCode
+	Description string
+}
+
+// Event is a timestamped annotation recorded on a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []slog.Attr
+}
+
+// Span is a timed operation within a trace. All methods are safe for
+// concurrent use; once End is called the span no longer changes.
+type Span struct {
+	mu     sync.Mutex
+	name   string
+	kind   SpanKind
+	sc     SpanContext
+	parent SpanContext
+	start  time.Time
+	end    time.Time
+	attrs  []slog.Attr
+	events []Event
+	status Status
+
+	sampler Sampler // only used by Start
+}
+
+type spanKey struct{} // unexported unique type
+
+// StartOption configures a span created by Start.
+type StartOption func(*Span)
+
+// WithSpanKind sets the kind of the new span. The default is SpanKindInternal.
+func WithSpanKind(kind SpanKind) StartOption {
+	return func(s *Span) { s.kind = kind }
+}
+
+// WithAttributes sets the initial attributes of the new span.
+func WithAttributes(attrs ...slog.Attr) StartOption {
+	return func(s *Span) { s.attrs = append(s.attrs, attrs...) }
+}
+
+// WithStartTime overrides the start time of the new span.
+func WithStartTime(t time.Time) StartOption {
+	return func(s *Span) { s.start = t }
+}
+
+// WithSampler makes the sampling decision of the new span with s instead of
+// the sampler installed with SetSampler.
+func WithSampler(sampler Sampler) StartOption {
+	return func(s *Span) { s.sampler = sampler }
+}
+
+// Start creates a span named name as a child of the span context in ctx, or as
+// the root of a new trace when ctx has none. The sampler installed with
+// SetSampler, or the one given with WithSampler, decides the sampled flag.
+// The returned context carries the new span and its span context.
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	s := &Span{name: name, kind: SpanKindInternal, start: time.Now()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if parent, ok := SpanContextFrom(ctx); ok {
+		s.parent = parent
+		s.sc = parent.Child()
+	} else {
+		s.sc = NewSpanContext()
+	}
+
+	sampler := s.sampler
+	if sampler == nil {
+		sampler = activeSampler()
+	}
+	s.sampler = nil
+	sampled := sampler.ShouldSample(SamplingParameters{
+		Parent:     s.parent,
+		TraceID:    s.sc.TraceID,
+		Name:       s.name,
+		Kind:       s.kind,
+		Attributes: s.attrs,
+	})
+	if sampled {
+		s.sc.TraceFlags |= FlagsSampled
+	} else {
+		s.sc.TraceFlags &^= FlagsSampled
+	}
+	return WithSpan(ctx, s), s
+}
+
+// WithSpan stores s as the current span of ctx.
+func WithSpan(ctx context.Context, s *Span) context.Context {
+	ctx = context.WithValue(ctx, spanKey{}, s)
+	return WithSpanContext(ctx, s.sc)
+}
+
+// SpanFrom returns the current span of ctx.
+func SpanFrom(ctx context.Context) (*Span, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	s, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok || s == nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// SpanContext returns the identifiers of the span.
+func (s *Span) SpanContext() SpanContext { return s.sc }
+
+// Parent returns the span context of the parent; it is invalid for root spans.
+func (s *Span) Parent() SpanContext { return s.parent }
+
+func (s *Span) Kind() SpanKind { return s.kind }
+
+func (s *Span) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// SetName renames the span, e.g. once the matched route is known.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.end.IsZero() {
+		s.name = name
+	}
+}
+
+func (s *Span) StartTime() time.Time { return s.start }
+
+// EndTime returns the end time, or the zero time while the span is running.
+func (s *Span) EndTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.end
+}
+
+// Duration returns the span duration, measured up to now while it is running.
+func (s *Span) Duration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+// IsRecording reports whether the span still accepts changes.
+func (s *Span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.end.IsZero()
+}
+
+// SetAttributes adds attrs to the span, replacing attributes with the same key.
+func (s *Span) SetAttributes(attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.end.IsZero() {
+		return
+	}
+	for _, a := range attrs {
+		replaced := false
+		for i := range s.attrs {
+			if s.attrs[i].Key == a.Key {
+				s.attrs[i] = a
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			s.attrs = append(s.attrs, a)
+		}
+	}
+}
+
+// Attributes returns a copy of the span attributes.
+func (s *Span) Attributes() []slog.Attr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slog.Attr(nil), s.attrs...)
+}
+
+// AddEvent records a named event at the current time.
+func (s *Span) AddEvent(name string, attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.end.IsZero() {
+		return
+	}
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+// Events returns a copy of the span events.
+func (s *Span) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// SetStatus sets the span status. The description is only kept for errors.
+func (s *Span) SetStatus(code StatusCode, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.end.IsZero() {
+		return
+	}
+	if code != StatusError {
+		description = ""
+	}
+	s.status = Status{Code: code, Description: description}
+}
+
+func (s *Span) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// RecordError adds an "exception" event for err and marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception", slog.String("exception.message", err.Error()))
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End records the end time of the span and hands sampled spans to the
+// installed processor. Only the first call has an effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	p := Processor()
+	if p == nil || !s.sc.IsSampled() {
+		s.mu.Unlock()
+		return
+	}
+	data := s.snapshotLocked()
+	s.mu.Unlock()
+	p.OnEnd(data)
+}
@@ -0,0 +1,82 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestStartParentChild(t *testing.T) {
+	ctx, root := trace.Start(context.Background(), "root")
+	if root.Parent().IsValid() {
+		t.Fatalf("root span should not have a parent")
+	}
+
+	childCtx, child := trace.Start(ctx, "child", trace.WithSpanKind(trace.SpanKindClient))
+	if child.SpanContext().TraceID != root.SpanContext().TraceID {
+		t.Errorf("child should share the trace ID of its parent")
+	}
+	if child.Parent().SpanID != root.SpanContext().SpanID {
+		t.Errorf("child parent = %s, want %s", child.Parent().SpanID, root.SpanContext().SpanID)
+	}
+	if child.Kind() != trace.SpanKindClient {
+		t.Errorf("kind = %s, want client", child.Kind())
+	}
+
+	got, ok := trace.SpanFrom(childCtx)
+	if !ok || got != child {
+		t.Fatalf("SpanFrom did not return the child span")
+	}
+	sc, ok := trace.SpanContextFrom(childCtx)
+	if !ok || sc.SpanID != child.SpanContext().SpanID {
+		t.Fatalf("SpanContextFrom did not return the child span context")
+	}
+}
+
+func TestStartContinuesRemoteParent(t *testing.T) {
+	remote, err := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := trace.Start(trace.WithSpanContext(context.Background(), remote), "server")
+	if span.SpanContext().TraceID != remote.TraceID || span.Parent().SpanID != remote.SpanID {
+		t.Fatalf("span does not continue the remote trace")
+	}
+	if span.SpanContext().Remote {
+		t.Errorf("local span must not be marked remote")
+	}
+}
+
+func TestSpanLifecycle(t *testing.T) {
+	_, span := trace.Start(context.Background(), "op", trace.WithAttributes(slog.String("a", "1")))
+
+	span.SetAttributes(slog.String("a", "2"), slog.Int("b", 3))
+	span.AddEvent("cache miss", slog.String("key", "k"))
+	span.RecordError(errors.New("boom"))
+
+	attrs := span.Attributes()
+	if len(attrs) != 2 || attrs[0].Value.String() != "2" {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+	if events := span.Events(); len(events) != 2 || events[0].Name != "cache miss" || events[1].Name != "exception" {
+		t.Errorf("unexpected events: %v", events)
+	}
+	if st := span.Status(); st.Code != trace.StatusError || st.Description != "boom" {
+		t.Errorf("unexpected status: %+v", st)
+	}
+
+	span.End()
+	end := span.EndTime()
+	if end.IsZero() || span.IsRecording() {
+		t.Fatalf("span should be ended")
+	}
+
+	span.End()
+	span.SetAttributes(slog.String("late", "x"))
+	if !span.EndTime().Equal(end) || len(span.Attributes()) != 2 {
+		t.Errorf("ended span must not change")
+	}
+}
@@ -0,0 +1,119 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// TraceID is the 128-bit identifier shared by every span of a trace.
+type TraceID [16]byte
+
+// SpanID is the 64-bit identifier of a single span.
+type SpanID [8]byte
+
+// TraceFlags carries the W3C trace-flags byte.
+type TraceFlags byte
+
+// FlagsSampled is the W3C "sampled" bit of TraceFlags.
+const FlagsSampled TraceFlags = 0x01
+
+// NewTraceID returns a random, valid TraceID.
+func NewTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		if _, err := rand.Read(t[:]); err != nil {
+			panic(err) // randomness failure = fatal
+		}
+	}
+	return t
+}
+
+// NewSpanID returns a random, valid SpanID.
+func NewSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		if _, err := rand.Read(s[:]); err != nil {
+			panic(err) // randomness failure = fatal
+		}
+	}
+	return s
+}
+
+// TraceIDFromHex parses a 32 character lowercase hex string into a TraceID.
+func TraceIDFromHex(s string) (TraceID, error) {
+	var t TraceID
+	if err := decodeHex(s, t[:]); err != nil {
+		return TraceID{}, fmt.Errorf("invalid trace ID %q: %w", s, err)
+	}
+	if !t.IsValid() {
+		return TraceID{}, fmt.Errorf("invalid trace ID %q: all zeros", s)
+	}
+	return t, nil
+}
+
+// SpanIDFromHex parses a 16 character lowercase hex string into a SpanID.
+func SpanIDFromHex(s string) (SpanID, error) {
+	var id SpanID
+	if err := decodeHex(s, id[:]); err != nil {
+		return SpanID{}, fmt.Errorf("invalid span ID %q: %w", s, err)
+	}
+	if !id.IsValid() {
+		return SpanID{}, fmt.Errorf("invalid span ID %q: all zeros", s)
+	}
+	return id, nil
+}
+
+// decodeHex decodes s into dst, requiring the exact length and lowercase digits.
+func decodeHex(s string, dst []byte) error {
+	if len(s) != 2*len(dst) {
+		return fmt.Errorf("expected %d hex characters, got %d", 2*len(dst), len(s))
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return fmt.Errorf("invalid hex character %q", c)
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (f TraceFlags) IsSampled() bool { return f&FlagsSampled != 0 }
+func (f TraceFlags) String() string  { return hex.EncodeToString([]byte{byte(f)}) }
+
+// SpanContext is the propagated part of a span: the identifiers, flags and
+// vendor state that travel between services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	TraceFlags TraceFlags
+	TraceState TraceState
+	Remote     bool // true when the span context was received from another process
+}
+
+// NewSpanContext starts a brand new, sampled trace.
+func NewSpanContext() SpanContext {
+	return SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID(), TraceFlags: FlagsSampled}
+}
+
+// IsValid reports whether both the trace and the span ID are set.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+func (sc SpanContext) IsSampled() bool { return sc.TraceFlags.IsSampled() }
+
+// Child returns a local span context in the same trace with a fresh span ID.
+// Flags and trace state are inherited from the parent.
+func (sc SpanContext) Child() SpanContext {
+	return SpanContext{
+		TraceID:    sc.TraceID,
+		SpanID:     NewSpanID(),
+		TraceFlags: sc.TraceFlags,
+		TraceState: sc.TraceState,
+	}
+}
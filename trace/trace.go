@@ -4,19 +4,42 @@ import (
 	"context"
 )
 
-type traceIdKey struct{} // unexported unique type
+type traceIdKey struct{}     // unexported unique type
+type spanContextKey struct{} // unexported unique type
 
 func WithTraceId(ctx context.Context, traceId string) context.Context {
 	return context.WithValue(ctx, traceIdKey{}, traceId)
 }
 
+// TraceIdFrom returns the transaction ID of the context. An ID stored with
+// WithTraceId takes precedence; otherwise the trace ID of the span context is
+// used.
 func TraceIdFrom(ctx context.Context) (string, bool) {
 	if ctx == nil {
 		return "", false
 	}
-	m, ok := ctx.Value(traceIdKey{}).(string)
-	if !ok || m == "" {
-		return "", false
+	if m, ok := ctx.Value(traceIdKey{}).(string); ok && m != "" {
+		return m, true
+	}
+	if sc, ok := SpanContextFrom(ctx); ok {
+		return sc.TraceID.String(), true
+	}
+	return "", false
+}
+
+// WithSpanContext stores sc as the current span context.
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFrom returns the current span context, if a valid one is set.
+func SpanContextFrom(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	if !ok || !sc.IsValid() {
+		return SpanContext{}, false
 	}
-	return m, true
+	return sc, true
 }
@@ -0,0 +1,241 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// W3C Trace Context header names.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const (
+	traceparentVersion = "00"
+	traceparentLen     = 55 // "00-" + 32 + "-" + 16 + "-" + 2
+
+	maxTraceStateMembers = 32
+	maxTraceStateKey     = 256
+	maxTraceStateValue   = 256
+)
+
+// ParseTraceparent parses a W3C traceparent header into a remote SpanContext.
+// Headers with a future version are accepted as long as the version 00 prefix
+// is well formed, as required by the specification.
+func ParseTraceparent(h string) (SpanContext, error) {
+	h = strings.TrimSpace(h)
+	if len(h) < traceparentLen {
+		return SpanContext{}, fmt.Errorf("traceparent too short: %q", h)
+	}
+	version := h[:2]
+	if err := decodeHex(version, make([]byte, 1)); err != nil || version == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	if version == traceparentVersion && len(h) != traceparentLen {
+		return SpanContext{}, fmt.Errorf("invalid traceparent length for version 00: %d", len(h))
+	}
+	if len(h) > traceparentLen && h[traceparentLen] != '-' {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", h)
+	}
+	if h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return SpanContext{}, fmt.Errorf("invalid traceparent delimiters: %q", h)
+	}
+
+	traceID, err := TraceIDFromHex(h[3:35])
+	if err != nil {
+		return SpanContext{}, err
+	}
+	spanID, err := SpanIDFromHex(h[36:52])
+	if err != nil {
+		return SpanContext{}, err
+	}
+	var flags [1]byte
+	if err := decodeHex(h[53:55], flags[:]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace flags %q: %w", h[53:55], err)
+	}
+
+	return SpanContext{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: TraceFlags(flags[0]) & FlagsSampled, // unknown flags must not be propagated
+		Remote:     true,
+	}, nil
+}
+
+// Traceparent formats the span context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return traceparentVersion + "-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + sc.TraceFlags.String()
+}
+
+// TraceState is the immutable, ordered list of vendor key/value pairs carried
+// in the W3C tracestate header. The zero value is an empty trace state.
+type TraceState struct {
+	members []traceStateMember
+}
+
+type traceStateMember struct {
+	Key   string
+	Value string
+}
+
+// ParseTraceState parses a W3C tracestate header. Empty list members are
+// ignored; any invalid member invalidates the whole header.
+func ParseTraceState(h string) (TraceState, error) {
+	var ts TraceState
+	seen := map[string]bool{}
+	for _, raw := range strings.Split(h, ",") {
+		raw = strings.Trim(raw, " \t")
+		if raw == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(raw, "=")
+		if !ok {
+			return TraceState{}, fmt.Errorf("invalid tracestate member %q", raw)
+		}
+		if err := validateTraceStateMember(key, value); err != nil {
+			return TraceState{}, err
+		}
+		if seen[key] {
+			return TraceState{}, fmt.Errorf("duplicate tracestate key %q", key)
+		}
+		seen[key] = true
+		ts.members = append(ts.members, traceStateMember{key, value})
+	}
+	if len(ts.members) > maxTraceStateMembers {
+		return TraceState{}, fmt.Errorf("tracestate has %d members, max is %d", len(ts.members), maxTraceStateMembers)
+	}
+	return ts, nil
+}
+
+// String formats the trace state as a tracestate header value.
+func (ts TraceState) String() string {
+	parts := make([]string, len(ts.members))
+	for i, m := range ts.members {
+		parts[i] = m.Key + "=" + m.Value
+	}
+	return strings.Join(parts, ",")
+}
+
+// Len returns the number of members.
+func (ts TraceState) Len() int { return len(ts.members) }
+
+// Get returns the value stored under key.
+func (ts TraceState) Get(key string) (string, bool) {
+	for _, m := range ts.members {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// Insert returns a copy of ts with key set to value and moved to the front,
+// as the specification requires for updated entries.
+func (ts TraceState) Insert(key, value string) (TraceState, error) {
+	if err := validateTraceStateMember(key, value); err != nil {
+		return ts, err
+	}
+	members := make([]traceStateMember, 0, len(ts.members)+1)
+	members = append(members, traceStateMember{key, value})
+	for _, m := range ts.members {
+		if m.Key != key {
+			members = append(members, m)
+		}
+	}
+	if len(members) > maxTraceStateMembers {
+		members = members[:maxTraceStateMembers]
+	}
+	return TraceState{members: members}, nil
+}
+
+// Delete returns a copy of ts without key.
+func (ts TraceState) Delete(key string) TraceState {
+	var members []traceStateMember
+	for _, m := range ts.members {
+		if m.Key != key {
+			members = append(members, m)
+		}
+	}
+	return TraceState{members: members}
+}
+
+func validateTraceStateMember(key, value string) error {
+	if !validTraceStateKey(key) {
+		return fmt.Errorf("invalid tracestate key %q", key)
+	}
+	if !validTraceStateValue(value) {
+		return fmt.Errorf("invalid tracestate value %q for key %q", value, key)
+	}
+	return nil
+}
+
+// validTraceStateKey accepts simple keys ("vendor") and multi-tenant keys
+// ("tenant@vendor").
+func validTraceStateKey(key string) bool {
+	if key == "" || len(key) > maxTraceStateKey {
+		return false
+	}
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return validKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && len(system) <= 14 &&
+		validKeyPart(tenant, false) && validKeyPart(system, true)
+}
+
+func validKeyPart(s string, mustStartAlpha bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+		case '0' <= c && c <= '9':
+			if i == 0 && mustStartAlpha {
+				return false
+			}
+		case c == '_' || c == '-' || c == '*' || c == '/':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validTraceStateValue(v string) bool {
+	if v == "" || len(v) > maxTraceStateValue || v[len(v)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrNoTraceparent is returned by ExtractTraceContext when no traceparent header is present.
+var ErrNoTraceparent = errors.New("no traceparent header")
+
+// ExtractTraceContext reads the traceparent and tracestate values of an
+// incoming request. An invalid tracestate is discarded without failing, as the
+// specification mandates.
+func ExtractTraceContext(traceparent, tracestate string) (SpanContext, error) {
+	if traceparent == "" {
+		return SpanContext{}, ErrNoTraceparent
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	if ts, err := ParseTraceState(tracestate); err == nil {
+		sc.TraceState = ts
+	}
+	return sc, nil
+}
@@ -0,0 +1,118 @@
+package trace_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/trace"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+		sampled bool
+	}{
+		{"valid sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"valid not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"future version with extra fields", "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what", false, true},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"version 00 too long", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", true, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, false},
+		{"bad delimiter", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"too short", "00-4bf92f", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := trace.ParseTraceparent(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", tt.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := sc.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("trace ID = %s", got)
+			}
+			if got := sc.SpanID.String(); got != "00f067aa0ba902b7" {
+				t.Errorf("span ID = %s", got)
+			}
+			if sc.IsSampled() != tt.sampled {
+				t.Errorf("sampled = %v, want %v", sc.IsSampled(), tt.sampled)
+			}
+			if !sc.Remote {
+				t.Errorf("expected remote span context")
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := trace.NewSpanContext()
+	got, err := trace.ParseTraceparent(sc.Traceparent())
+	if err != nil {
+		t.Fatalf("ParseTraceparent(%q) failed: %v", sc.Traceparent(), err)
+	}
+	if got.TraceID != sc.TraceID || got.SpanID != sc.SpanID || got.TraceFlags != sc.TraceFlags {
+		t.Errorf("round trip mismatch: got %s, want %s", got.Traceparent(), sc.Traceparent())
+	}
+}
+
+func TestParseTraceState(t *testing.T) {
+	ts, err := trace.ParseTraceState("rojo=00f067aa0ba902b7, ,congo=t61rcWkgMzE,tenant@vendor=x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ts.Len() != 3 {
+		t.Fatalf("expected 3 members, got %d", ts.Len())
+	}
+	if v, ok := ts.Get("congo"); !ok || v != "t61rcWkgMzE" {
+		t.Errorf("Get(congo) = %q, %v", v, ok)
+	}
+	if got := ts.String(); got != "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,tenant@vendor=x" {
+		t.Errorf("String() = %q", got)
+	}
+
+	ts, err = ts.Insert("congo", "new")
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if got := ts.String(); got != "congo=new,rojo=00f067aa0ba902b7,tenant@vendor=x" {
+		t.Errorf("after Insert String() = %q", got)
+	}
+	if got := ts.Delete("rojo").String(); got != "congo=new,tenant@vendor=x" {
+		t.Errorf("after Delete String() = %q", got)
+	}
+
+	for _, bad := range []string{"Upper=1", "a=b=c", "a=1,a=2", "novalue", "1abc=x"} {
+		if _, err := trace.ParseTraceState(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestTraceIdFrom(t *testing.T) {
+	sc := trace.NewSpanContext()
+	ctx := trace.WithSpanContext(context.Background(), sc)
+
+	id, ok := trace.TraceIdFrom(ctx)
+	if !ok || id != sc.TraceID.String() {
+		t.Fatalf("TraceIdFrom = %q, %v; want %s", id, ok, sc.TraceID)
+	}
+
+	id, ok = trace.TraceIdFrom(trace.WithTraceId(ctx, "legacy"))
+	if !ok || id != "legacy" {
+		t.Fatalf("explicit trace ID should take precedence, got %q", id)
+	}
+
+	if _, ok := trace.TraceIdFrom(context.Background()); ok {
+		t.Fatalf("expected no trace ID in empty context")
+	}
+}
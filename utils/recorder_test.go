@@ -0,0 +1,159 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Guadalsistema/net-utils/utils"
+)
+
+func TestResponseRecorderCapsCapture(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rr := &utils.ResponseRecorder{ResponseWriter: rec, Limit: 4, TailLimit: 3}
+
+	for _, chunk := range []string{"ab", "cdef", "ghij", "k"} {
+		if n, err := rr.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	if rec.Body.String() != "abcdefghijk" {
+		t.Errorf("underlying writer got %q", rec.Body.String())
+	}
+	if rr.Buf.String() != "abcd" {
+		t.Errorf("prefix = %q, want abcd", rr.Buf.String())
+	}
+	if rr.Size != 11 || !rr.Truncated() {
+		t.Errorf("size = %d, truncated = %v", rr.Size, rr.Truncated())
+	}
+	if got := string(rr.Tail()); got != "ijk" {
+		t.Errorf("tail = %q, want ijk", got)
+	}
+}
+
+func TestResponseRecorderTailDoesNotOverlapPrefix(t *testing.T) {
+	rr := &utils.ResponseRecorder{ResponseWriter: httptest.NewRecorder(), Limit: 4, TailLimit: 10}
+	rr.Write([]byte("abcdef"))
+	if got := string(rr.Tail()); got != "ef" {
+		t.Errorf("tail = %q, want only the bytes after the prefix", got)
+	}
+
+	big := &utils.ResponseRecorder{ResponseWriter: httptest.NewRecorder(), Limit: 2, TailLimit: 3}
+	big.Write(bytes.Repeat([]byte("x"), 1000))
+	big.Write([]byte("yz"))
+	if got := string(big.Tail()); got != "xyz" {
+		t.Errorf("tail = %q, want xyz", got)
+	}
+}
+
+func TestResponseRecorderLimitModes(t *testing.T) {
+	body := strings.Repeat("a", 100)
+
+	unlimited := &utils.ResponseRecorder{ResponseWriter: httptest.NewRecorder()}
+	unlimited.Write([]byte(body))
+	if unlimited.Buf.Len() != 100 || unlimited.Truncated() {
+		t.Errorf("zero Limit should keep the whole body")
+	}
+
+	none := &utils.ResponseRecorder{ResponseWriter: httptest.NewRecorder(), Limit: -1}
+	none.Write([]byte(body))
+	if none.Buf.Len() != 0 || none.Size != 100 {
+		t.Errorf("negative Limit should only count bytes, kept %d of %d", none.Buf.Len(), none.Size)
+	}
+}
+
+func TestResponseRecorderOptionalInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rr := &utils.ResponseRecorder{ResponseWriter: rec}
+
+	var w http.ResponseWriter = rr
+	if _, ok := w.(http.Flusher); !ok {
+		t.Fatal("recorder must implement http.Flusher")
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		t.Fatal("recorder must implement http.Hijacker")
+	}
+	if _, ok := w.(io.ReaderFrom); !ok {
+		t.Fatal("recorder must implement io.ReaderFrom")
+	}
+
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		t.Fatalf("ResponseController.Flush failed: %v", err)
+	}
+	if !rec.Flushed || rr.Flushes != 1 {
+		t.Errorf("flush did not reach the wrapped writer")
+	}
+
+	if _, _, err := http.NewResponseController(w).Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported hijacking a recorder, got %v", err)
+	}
+	if err := rr.Push("/app.js", nil); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported for push, got %v", err)
+	}
+
+	n, err := io.Copy(w, strings.NewReader("streamed"))
+	if err != nil || n != 8 || rec.Body.String() != "streamed" || rr.Buf.String() != "streamed" {
+		t.Errorf("io.Copy through ReadFrom: n=%d err=%v body=%q captured=%q", n, err, rec.Body.String(), rr.Buf.String())
+	}
+}
+
+func TestResponseRecorderReadFromFastPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rr := &utils.ResponseRecorder{ResponseWriter: w, Limit: -1}
+		if _, err := io.Copy(rr, strings.NewReader(strings.Repeat("z", 5000))); err != nil {
+			t.Errorf("copy failed: %v", err)
+		}
+		if rr.Size != 5000 || rr.Buf.Len() != 0 {
+			t.Errorf("size = %d, captured = %d", rr.Size, rr.Buf.Len())
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) != 5000 {
+		t.Errorf("client received %d bytes", len(body))
+	}
+}
+
+func TestResponseRecorderHijack(t *testing.T) {
+	done := make(chan *utils.ResponseRecorder, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rr := &utils.ResponseRecorder{ResponseWriter: w}
+		conn, _, err := rr.Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\nhello"))
+		conn.Close()
+		done <- rr
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	io.ReadAll(conn)
+
+	rr := <-done
+	if !rr.Hijacked {
+		t.Fatal("recorder not marked hijacked")
+	}
+	if _, written := rr.HijackedBytes(); written != 41 {
+		t.Errorf("written = %d, want 41", written)
+	}
+}
@@ -1,11 +1,14 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
+	"sync/atomic"
 )
 
 /* -------------------------------------------------------------------------- */
@@ -42,10 +45,26 @@ func (c *CappedWriter) Write(p []byte) (int, error) {
 }
 
 // ResponseRecorder lets us capture body & status that downstream writes.
+// Every byte is passed through to the wrapped ResponseWriter; only the first
+// Limit bytes are kept in Buf and the last TailLimit bytes in the tail, so
+// large responses can be logged without buffering them whole.
 type ResponseRecorder struct {
 	http.ResponseWriter
 	Status int
 	Buf    bytes.Buffer
+	// Limit caps the bytes kept in Buf. Zero keeps the whole body and a
+	// negative value keeps nothing.
+	Limit int64
+	// TailLimit is the number of bytes kept from the end of the body.
+	TailLimit int64
+	// Size counts every byte written, captured or not.
+	Size int64
+	// Flushes counts calls to Flush, a sign of a streaming response.
+	Flushes int
+	// Hijacked is set once the handler took over the connection.
+	Hijacked bool
+	tail     []byte
+	conn     *countingConn
 }
 
 func (rr *ResponseRecorder) WriteHeader(code int) {
@@ -54,8 +73,141 @@ func (rr *ResponseRecorder) WriteHeader(code int) {
 }
 
 func (rr *ResponseRecorder) Write(p []byte) (int, error) {
-	rr.Buf.Write(p) // copy to our buffer
-	return rr.ResponseWriter.Write(p)
+	n, err := rr.ResponseWriter.Write(p)
+	rr.capture(p[:n])
+	return n, err
+}
+
+// capture records p in the prefix buffer and the tail.
+func (rr *ResponseRecorder) capture(p []byte) {
+	rr.Size += int64(len(p))
+	switch {
+	case rr.Limit == 0:
+		rr.Buf.Write(p) // copy to our buffer
+	case rr.Limit > 0:
+		if remain := rr.Limit - int64(rr.Buf.Len()); remain > 0 {
+			rr.Buf.Write(p[:min(int64(len(p)), remain)])
+		}
+	}
+	if rr.TailLimit <= 0 {
+		return
+	}
+	if int64(len(p)) >= rr.TailLimit {
+		rr.tail = append(rr.tail[:0], p[int64(len(p))-rr.TailLimit:]...)
+		return
+	}
+	rr.tail = append(rr.tail, p...)
+	if over := int64(len(rr.tail)) - rr.TailLimit; over > 0 {
+		rr.tail = append(rr.tail[:0], rr.tail[over:]...)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter.
+func (rr *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Flush implements http.Flusher; it is a no-op when the wrapped writer
+// cannot flush.
+func (rr *ResponseRecorder) Flush() {
+	_ = rr.FlushError()
+}
+
+// FlushError flushes the wrapped writer, returning http.ErrNotSupported when
+// it cannot flush. http.ResponseController prefers it over Flush.
+func (rr *ResponseRecorder) FlushError() error {
+	rr.Flushes++
+	return http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker. The returned connection counts the bytes
+// read and written through it, see HijackedBytes.
+func (rr *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	rr.Hijacked = true
+	rr.conn = &countingConn{Conn: conn}
+	return rr.conn, brw, nil
+}
+
+// HijackedBytes returns the bytes read from and written to the hijacked
+// connection so far. Bytes served from the bufio.ReadWriter buffers returned
+// by Hijack are not included.
+func (rr *ResponseRecorder) HijackedBytes() (read, written int64) {
+	if rr.conn == nil {
+		return 0, 0
+	}
+	return rr.conn.read.Load(), rr.conn.written.Load()
+}
+
+// Push implements http.Pusher when the wrapped writer supports HTTP/2 push.
+func (rr *ResponseRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rr.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom so io.Copy keeps using the fast path of
+// the wrapped writer (e.g. sendfile) when nothing has to be captured.
+func (rr *ResponseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := rr.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{rr}, src) // hide ReadFrom to avoid recursion
+	}
+	if rr.Limit < 0 && rr.TailLimit <= 0 {
+		n, err := rf.ReadFrom(src)
+		rr.Size += n
+		return n, err
+	}
+	return rf.ReadFrom(io.TeeReader(src, captureWriter{rr}))
+}
+
+// captureWriter feeds the bytes copied by ReadFrom into the recorder.
+type captureWriter struct{ rr *ResponseRecorder }
+
+func (c captureWriter) Write(p []byte) (int, error) {
+	c.rr.capture(p)
+	return len(p), nil
+}
+
+// countingConn counts the traffic of a hijacked connection.
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Truncated reports whether bytes were written that Buf does not hold.
+func (rr *ResponseRecorder) Truncated() bool {
+	return rr.Size > int64(rr.Buf.Len())
+}
+
+// Tail returns the end of the body that is not already part of Buf, at most
+// TailLimit bytes.
+func (rr *ResponseRecorder) Tail() []byte {
+	beyond := rr.Size - int64(rr.Buf.Len())
+	if beyond <= 0 || len(rr.tail) == 0 {
+		return nil
+	}
+	if int64(len(rr.tail)) > beyond {
+		return rr.tail[int64(len(rr.tail))-beyond:]
+	}
+	return rr.tail
 }
 
 func RandomKey(n int) string {